@@ -0,0 +1,412 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// AccessToken is a bearer token used to authenticate with the SignalR service along with the time it expires
+	AccessToken struct {
+		Token     string
+		ExpiresOn time.Time
+	}
+
+	// TokenProvider supplies the bearer tokens used to authenticate REST calls, negotiation and WebSocket connections
+	// with the SignalR service. The audience is the URL of the resource the token will be presented to.
+	TokenProvider interface {
+		GetToken(ctx context.Context, audience string) (AccessToken, error)
+	}
+
+	// clientTokenProvider is implemented by token providers which can mint client access tokens themselves rather than
+	// requesting them from the service
+	clientTokenProvider interface {
+		GetClientToken(ctx context.Context, req clientTokenRequest) (AccessToken, error)
+	}
+
+	// AADTokenProviderOption provides a way to configure an Azure Active Directory token provider at time of
+	// construction
+	AADTokenProviderOption func(*aadTokenProvider) error
+
+	// AccessKeyTokenProviderOption provides a way to configure an access key token provider at time of construction
+	AccessKeyTokenProviderOption func(*accessKeyTokenProvider) error
+
+	accessKeyTokenProvider struct {
+		mu            sync.RWMutex
+		key           string
+		secondaryKey  string
+		lifetime      time.Duration
+		notBeforeSkew time.Duration
+	}
+
+	tokenLifetimeKey struct{}
+
+	// tokenProviderError wraps an error acquiring a token, which says nothing about the health of the service instance
+	// the token is for
+	tokenProviderError struct {
+		err error
+	}
+
+	aadTokenProvider struct {
+		tenantID           string
+		clientID           string
+		clientSecret       string
+		authorityHost      string
+		identityEndpoint   string
+		identityHeader     string
+		httpClient         *http.Client
+		buildTokenRequest  func(ctx context.Context, p *aadTokenProvider) (*http.Request, error)
+		tokenRequestErrMsg string
+	}
+
+	aadTokenResponse struct {
+		AccessToken string          `json:"access_token"`
+		ExpiresIn   flexibleSeconds `json:"expires_in"`
+		ExpiresOn   flexibleSeconds `json:"expires_on"`
+	}
+
+	// flexibleSeconds decodes a number of seconds which identity endpoints return as either a JSON number or string
+	flexibleSeconds int64
+)
+
+const (
+	// AADResource is the Azure Active Directory resource SignalR service tokens are issued for
+	AADResource = "https://signalr.azure.com"
+
+	// DefaultAuthorityHost is the Azure Active Directory authority used to acquire tokens with a client secret
+	DefaultAuthorityHost = "https://login.microsoftonline.com"
+
+	// DefaultManagedIdentityEndpoint is the Azure Instance Metadata Service endpoint used to acquire managed identity
+	// tokens when not running in App Service or Functions
+	DefaultManagedIdentityEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	// DefaultTokenLifetime is how long tokens signed with an access key are valid for when no lifetime is specified
+	DefaultTokenLifetime = 2 * time.Hour
+)
+
+// ClientWithTokenProvider configures the client to authenticate with the provided `TokenProvider` rather than the
+// credentials in its connection strings
+func ClientWithTokenProvider(provider TokenProvider) ClientOption {
+	return func(client *Client) error {
+		if provider == nil {
+			return errors.New("token provider must not be nil")
+		}
+		client.tokenProvider = provider
+		return nil
+	}
+}
+
+// ServiceManagerWithTokenProvider configures the service manager to authenticate with the provided `TokenProvider`
+// rather than the credentials in its connection string
+func ServiceManagerWithTokenProvider(provider TokenProvider) ServiceManagerOption {
+	return func(sm *ServiceManager) error {
+		if provider == nil {
+			return errors.New("token provider must not be nil")
+		}
+		sm.tokenProvider = provider
+		return nil
+	}
+}
+
+// AADWithAuthorityHost configures the Azure Active Directory authority tokens are requested from
+func AADWithAuthorityHost(host string) AADTokenProviderOption {
+	return func(p *aadTokenProvider) error {
+		p.authorityHost = strings.TrimSuffix(host, "/")
+		return nil
+	}
+}
+
+// AADWithManagedIdentityEndpoint configures the endpoint managed identity tokens are requested from. By default the
+// App Service endpoint from the IDENTITY_ENDPOINT environment variable is used if set, otherwise the Azure Instance
+// Metadata Service.
+func AADWithManagedIdentityEndpoint(endpoint string) AADTokenProviderOption {
+	return func(p *aadTokenProvider) error {
+		p.identityEndpoint = endpoint
+		p.identityHeader = ""
+		return nil
+	}
+}
+
+// AADWithHTTPClient configures the HTTP client used to request tokens
+func AADWithHTTPClient(client *http.Client) AADTokenProviderOption {
+	return func(p *aadTokenProvider) error {
+		p.httpClient = client
+		return nil
+	}
+}
+
+// AccessKeyWithTokenLifetime configures how long the tokens signed by the provider are valid for
+func AccessKeyWithTokenLifetime(lifetime time.Duration) AccessKeyTokenProviderOption {
+	return func(p *accessKeyTokenProvider) error {
+		if lifetime <= 0 {
+			return errors.New("token lifetime must be positive")
+		}
+		p.lifetime = lifetime
+		return nil
+	}
+}
+
+// AccessKeyWithSecondaryKey configures a secondary access key the provider signs with if the service rejects tokens
+// signed with the primary key, such as while a rotated key is rolled out
+func AccessKeyWithSecondaryKey(key string) AccessKeyTokenProviderOption {
+	return func(p *accessKeyTokenProvider) error {
+		p.secondaryKey = key
+		return nil
+	}
+}
+
+// AccessKeyWithNotBeforeSkew configures how far the not-before time of the tokens signed by the provider is backdated
+// so hosts whose clocks run behind the service's still accept them
+func AccessKeyWithNotBeforeSkew(skew time.Duration) AccessKeyTokenProviderOption {
+	return func(p *accessKeyTokenProvider) error {
+		if skew < 0 {
+			return errors.New("not-before skew must not be negative")
+		}
+		p.notBeforeSkew = skew
+		return nil
+	}
+}
+
+// NewAccessKeyTokenProvider creates a `TokenProvider` which signs tokens with a SignalR access key
+func NewAccessKeyTokenProvider(key string, opts ...AccessKeyTokenProviderOption) (TokenProvider, error) {
+	p := &accessKeyTokenProvider{
+		key:      key,
+		lifetime: DefaultTokenLifetime,
+	}
+
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// WithTokenLifetime returns a context which overrides the lifetime of the tokens signed with an access key for the
+// operations it is passed to
+func WithTokenLifetime(ctx context.Context, lifetime time.Duration) context.Context {
+	return context.WithValue(ctx, tokenLifetimeKey{}, lifetime)
+}
+
+// tokenLifetimeFromContext returns the token lifetime override of the context, if any
+func tokenLifetimeFromContext(ctx context.Context) (time.Duration, bool) {
+	lifetime, ok := ctx.Value(tokenLifetimeKey{}).(time.Duration)
+	return lifetime, ok && lifetime > 0
+}
+
+// NewClientSecretTokenProvider creates a `TokenProvider` which acquires Azure Active Directory tokens for a service
+// principal using a client secret
+func NewClientSecretTokenProvider(tenantID, clientID, clientSecret string, opts ...AADTokenProviderOption) (TokenProvider, error) {
+	if tenantID == "" || clientID == "" || clientSecret == "" {
+		return nil, errors.New("tenantID, clientID and clientSecret are required to authenticate with a client secret")
+	}
+
+	p := &aadTokenProvider{
+		tenantID:           tenantID,
+		clientID:           clientID,
+		clientSecret:       clientSecret,
+		authorityHost:      DefaultAuthorityHost,
+		httpClient:         newHTTPClient(),
+		buildTokenRequest:  clientSecretTokenRequest,
+		tokenRequestErrMsg: "failed to acquire a token with a client secret",
+	}
+
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// NewManagedIdentityTokenProvider creates a `TokenProvider` which acquires Azure Active Directory tokens for the
+// managed identity of the host. Provide a clientID to use a user-assigned identity or an empty string for the
+// system-assigned identity.
+func NewManagedIdentityTokenProvider(clientID string, opts ...AADTokenProviderOption) (TokenProvider, error) {
+	p := &aadTokenProvider{
+		clientID:           clientID,
+		identityEndpoint:   DefaultManagedIdentityEndpoint,
+		httpClient:         newHTTPClient(),
+		buildTokenRequest:  managedIdentityTokenRequest,
+		tokenRequestErrMsg: "failed to acquire a managed identity token",
+	}
+
+	if endpoint := os.Getenv("IDENTITY_ENDPOINT"); endpoint != "" {
+		p.identityEndpoint = endpoint
+		p.identityHeader = os.Getenv("IDENTITY_HEADER")
+	}
+
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// newTokenProvider creates the `TokenProvider` described by the credentials in a connection string
+func newTokenProvider(parsed *ParsedConnString, opts ...AccessKeyTokenProviderOption) (TokenProvider, error) {
+	if parsed.AuthType != AuthTypeAAD {
+		return NewAccessKeyTokenProvider(parsed.Key, opts...)
+	}
+
+	if parsed.ClientSecret != "" {
+		return NewClientSecretTokenProvider(parsed.TenantID, parsed.ClientID, parsed.ClientSecret)
+	}
+	return NewManagedIdentityTokenProvider(parsed.ClientID)
+}
+
+// GetToken signs a token for the audience with the access key. The lifetime of the token can be overridden for an
+// operation with `WithTokenLifetime`.
+func (p *accessKeyTokenProvider) GetToken(ctx context.Context, audience string) (AccessToken, error) {
+	lifetime := p.lifetime
+	if override, ok := tokenLifetimeFromContext(ctx); ok {
+		lifetime = override
+	}
+	return p.sign(clientTokenRequest{Audience: audience, ExpiresAfter: lifetime})
+}
+
+// GetClientToken signs a client access token identifying the user for the audience with the access key
+func (p *accessKeyTokenProvider) GetClientToken(_ context.Context, req clientTokenRequest) (AccessToken, error) {
+	return p.sign(req)
+}
+
+func (p *accessKeyTokenProvider) sign(req clientTokenRequest) (AccessToken, error) {
+	req.NotBeforeSkew = p.notBeforeSkew
+	token, err := generateToken(p.primaryKey(), req)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	return AccessToken{
+		Token:     token,
+		ExpiresOn: time.Now().Add(req.ExpiresAfter),
+	}, nil
+}
+
+// GetToken acquires an Azure Active Directory token for the SignalR service; Azure Active Directory tokens are valid
+// for any URL of the service, so the audience is not used
+func (p *aadTokenProvider) GetToken(ctx context.Context, _ string) (AccessToken, error) {
+	req, err := p.buildTokenRequest(ctx, p)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	res, err := p.httpClient.Do(req)
+	defer closeRes(res)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	bodyBits, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	if res.StatusCode > 399 {
+		return AccessToken{}, TokenRequestError{
+			Message:    p.tokenRequestErrMsg,
+			StatusCode: res.StatusCode,
+			Body:       string(bodyBits),
+		}
+	}
+
+	var tokenRes aadTokenResponse
+	if err := json.Unmarshal(bodyBits, &tokenRes); err != nil {
+		return AccessToken{}, err
+	}
+
+	if tokenRes.AccessToken == "" {
+		return AccessToken{}, TokenRequestError{
+			Message:    p.tokenRequestErrMsg + ": the response did not include an access_token",
+			StatusCode: res.StatusCode,
+		}
+	}
+
+	expiresOn := time.Unix(int64(tokenRes.ExpiresOn), 0)
+	if tokenRes.ExpiresOn == 0 {
+		expiresOn = time.Now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second)
+	}
+
+	return AccessToken{
+		Token:     tokenRes.AccessToken,
+		ExpiresOn: expiresOn,
+	}, nil
+}
+
+func clientSecretTokenRequest(ctx context.Context, p *aadTokenProvider) (*http.Request, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", p.clientID)
+	form.Set("client_secret", p.clientSecret)
+	form.Set("scope", AADResource+"/.default")
+
+	tokenURI := p.authorityHost + "/" + url.PathEscape(p.tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequest(http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req.WithContext(ctx), nil
+}
+
+func managedIdentityTokenRequest(ctx context.Context, p *aadTokenProvider) (*http.Request, error) {
+	query := url.Values{}
+	query.Set("resource", AADResource)
+	if p.clientID != "" {
+		query.Set("client_id", p.clientID)
+	}
+
+	if p.identityHeader != "" {
+		query.Set("api-version", "2019-08-01")
+	} else {
+		query.Set("api-version", "2018-02-01")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.identityEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.identityHeader != "" {
+		req.Header.Set("X-IDENTITY-HEADER", p.identityHeader)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+	return req.WithContext(ctx), nil
+}
+
+// UnmarshalJSON accepts the number of seconds as either a JSON number or a string containing a number
+func (fs *flexibleSeconds) UnmarshalJSON(bits []byte) error {
+	str := strings.Trim(string(bits), `"`)
+	if str == "" || str == "null" {
+		*fs = 0
+		return nil
+	}
+
+	seconds, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return err
+	}
+	*fs = flexibleSeconds(seconds)
+	return nil
+}
+
+func (tpe tokenProviderError) Error() string {
+	return tpe.err.Error()
+}
+
+// Unwrap returns the error of the token provider
+func (tpe tokenProviderError) Unwrap() error {
+	return tpe.err
+}
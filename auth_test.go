@@ -0,0 +1,132 @@
+package signalr_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+)
+
+func TestClientSecretTokenProvider(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	aad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tenant1/oauth2/v2.0/token", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "client1", r.PostForm.Get("client_id"))
+		assert.Equal(t, "secret1", r.PostForm.Get("client_secret"))
+		assert.Equal(t, signalr.AADResource+"/.default", r.PostForm.Get("scope"))
+		writeJSON(t, w, map[string]interface{}{"access_token": "aad-token", "expires_in": 3600, "token_type": "Bearer"})
+	}))
+	defer aad.Close()
+
+	provider, err := signalr.NewClientSecretTokenProvider("tenant1", "client1", "secret1", signalr.AADWithAuthorityHost(aad.URL))
+	require.NoError(t, err)
+
+	token, err := provider.GetToken(ctx, "https://foo.service.signalr.net/api/hubs/hub1/:send")
+	require.NoError(t, err)
+	assert.Equal(t, "aad-token", token.Token)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.ExpiresOn, time.Minute)
+
+	withServiceManager(t, http.StatusAccepted, func(ctx context.Context, sm *signalr.ServiceManager, requests <-chan recordedRequest) {
+		require.NoError(t, sm.AddUserToGroup(ctx, "group1", "user1"))
+		assert.Equal(t, "Bearer aad-token", (<-requests).Auth)
+	}, signalr.ServiceManagerWithTokenProvider(provider))
+}
+
+func TestClientSecretTokenProvider_Failure(t *testing.T) {
+	aad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+	}))
+	defer aad.Close()
+
+	provider, err := signalr.NewClientSecretTokenProvider("tenant1", "client1", "wrong", signalr.AADWithAuthorityHost(aad.URL))
+	require.NoError(t, err)
+
+	_, err = provider.GetToken(context.Background(), "")
+	require.Error(t, err)
+	tre, ok := err.(signalr.TokenRequestError)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusUnauthorized, tre.StatusCode)
+}
+
+func TestManagedIdentityTokenProvider(t *testing.T) {
+	expiresOn := time.Now().Add(30 * time.Minute).Unix()
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get("Metadata"))
+		assert.Equal(t, signalr.AADResource, r.URL.Query().Get("resource"))
+		assert.Equal(t, "user-assigned", r.URL.Query().Get("client_id"))
+		writeJSON(t, w, map[string]interface{}{"access_token": "mi-token", "expires_on": strconv.FormatInt(expiresOn, 10)})
+	}))
+	defer imds.Close()
+
+	provider, err := signalr.NewManagedIdentityTokenProvider("user-assigned", signalr.AADWithManagedIdentityEndpoint(imds.URL))
+	require.NoError(t, err)
+
+	token, err := provider.GetToken(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, "mi-token", token.Token)
+	assert.Equal(t, expiresOn, token.ExpiresOn.Unix())
+}
+
+func TestServiceManager_AADConnectionString(t *testing.T) {
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "identity-header", r.Header.Get("X-IDENTITY-HEADER"))
+		writeJSON(t, w, map[string]interface{}{"access_token": "app-service-token", "expires_in": "3600"})
+	}))
+	defer identity.Close()
+
+	for key, value := range map[string]string{"IDENTITY_ENDPOINT": identity.URL, "IDENTITY_HEADER": "identity-header"} {
+		prev, ok := os.LookupEnv(key)
+		require.NoError(t, os.Setenv(key, value))
+		defer func(key, prev string, ok bool) {
+			if ok {
+				_ = os.Setenv(key, prev)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		}(key, prev, ok)
+	}
+
+	var auth string
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		assert.Equal(t, "/api/hubs/hub1/:generateToken", r.URL.EscapedPath())
+		assert.Equal(t, "user1", r.URL.Query().Get("userId"))
+		assert.Equal(t, "60", r.URL.Query().Get("minutesToExpire"))
+		writeJSON(t, w, map[string]interface{}{"token": "client-token"})
+	}))
+	defer service.Close()
+
+	sm, err := signalr.NewServiceManager("Endpoint="+service.URL+";AuthType=aad;Version=1.0;", "hub1")
+	require.NoError(t, err)
+
+	token, err := sm.GenerateClientToken(context.Background(), "user1", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "client-token", token)
+	assert.Equal(t, "Bearer app-service-token", auth)
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(body))
+}
+
+func TestTokenProvider_Nil(t *testing.T) {
+	_, err := signalr.NewClient("Endpoint=https://foo.service.signalr.net;AccessKey=foo;Version=1.0;", "hub1", signalr.ClientWithTokenProvider(nil))
+	assert.Error(t, err)
+	_, err = signalr.NewServiceManager("Endpoint=https://foo.service.signalr.net;AccessKey=foo;Version=1.0;", "hub1", signalr.ServiceManagerWithTokenProvider(nil))
+	assert.Error(t, err)
+}
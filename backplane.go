@@ -0,0 +1,144 @@
+package signalr
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type (
+	// Backplane shares the state of a hub between the nodes of a scaled out `HubServer`. It follows the Redis data
+	// model: messages published to a channel are delivered to every subscriber of the channel on every node, and
+	// group membership and presence are kept in sets shared by all nodes.
+	//
+	// Nodes publish each message with the IDs of the connections it is for, resolved from the shared sets, and each
+	// node delivers it to those of its own connections, so groups and users span nodes consistently. Connections are
+	// removed from the shared sets as they disconnect; the connections of a node which stops abruptly remain until they
+	// are removed by other means.
+	Backplane interface {
+		// Publish sends the message to every subscriber of the channel, including those of the publishing node
+		Publish(ctx context.Context, channel string, message []byte) error
+		// Subscribe calls deliver with each message published to the channel, one at a time, until unsubscribe is
+		// called
+		Subscribe(ctx context.Context, channel string, deliver func(message []byte)) (unsubscribe func(), err error)
+		// SetAdd adds the members to the set
+		SetAdd(ctx context.Context, key string, members ...string) error
+		// SetRemove removes the members from the set
+		SetRemove(ctx context.Context, key string, members ...string) error
+		// SetMembers returns the members of the set, which is empty if it does not exist
+		SetMembers(ctx context.Context, key string) ([]string, error)
+		// SetIsMember reports whether the member is in the set
+		SetIsMember(ctx context.Context, key, member string) (bool, error)
+		// Delete removes the set
+		Delete(ctx context.Context, key string) error
+	}
+
+	// MemoryBackplane is a `Backplane` shared by hub servers in the same process. Messages are delivered synchronously
+	// from `Publish`.
+	MemoryBackplane struct {
+		mu   sync.RWMutex
+		sets map[string]map[string]bool
+		subs map[string]map[*memorySubscription]bool
+	}
+
+	memorySubscription struct {
+		mu      sync.Mutex
+		deliver func(message []byte)
+	}
+)
+
+// NewMemoryBackplane creates an empty in-memory backplane
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		sets: make(map[string]map[string]bool),
+		subs: make(map[string]map[*memorySubscription]bool),
+	}
+}
+
+// Publish sends the message to every subscriber of the channel
+func (mb *MemoryBackplane) Publish(ctx context.Context, channel string, message []byte) error {
+	mb.mu.RLock()
+	subs := make([]*memorySubscription, 0, len(mb.subs[channel]))
+	for sub := range mb.subs[channel] {
+		subs = append(subs, sub)
+	}
+	mb.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.mu.Lock()
+		sub.deliver(message)
+		sub.mu.Unlock()
+	}
+	return nil
+}
+
+// Subscribe calls deliver with each message published to the channel until unsubscribe is called
+func (mb *MemoryBackplane) Subscribe(ctx context.Context, channel string, deliver func(message []byte)) (func(), error) {
+	sub := &memorySubscription{deliver: deliver}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.subs[channel] == nil {
+		mb.subs[channel] = make(map[*memorySubscription]bool)
+	}
+	mb.subs[channel][sub] = true
+
+	return func() {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		delete(mb.subs[channel], sub)
+	}, nil
+}
+
+// SetAdd adds the members to the set
+func (mb *MemoryBackplane) SetAdd(ctx context.Context, key string, members ...string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.sets[key] == nil {
+		mb.sets[key] = make(map[string]bool)
+	}
+	for _, member := range members {
+		mb.sets[key][member] = true
+	}
+	return nil
+}
+
+// SetRemove removes the members from the set
+func (mb *MemoryBackplane) SetRemove(ctx context.Context, key string, members ...string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for _, member := range members {
+		delete(mb.sets[key], member)
+	}
+	if len(mb.sets[key]) == 0 {
+		delete(mb.sets, key)
+	}
+	return nil
+}
+
+// SetMembers returns the members of the set in order
+func (mb *MemoryBackplane) SetMembers(ctx context.Context, key string) ([]string, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	members := make([]string, 0, len(mb.sets[key]))
+	for member := range mb.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// SetIsMember reports whether the member is in the set
+func (mb *MemoryBackplane) SetIsMember(ctx context.Context, key, member string) (bool, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	return mb.sets[key][member], nil
+}
+
+// Delete removes the set
+func (mb *MemoryBackplane) Delete(ctx context.Context, key string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	delete(mb.sets, key)
+	return nil
+}
@@ -0,0 +1,141 @@
+package signalr_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+
+	"github.com/devigned/signalr-go"
+	"github.com/devigned/signalr-go/redisbackplane"
+	"github.com/devigned/signalr-go/signalrtest"
+)
+
+func TestHubServer_MemoryBackplane(t *testing.T) {
+	testScaleOut(t, signalr.NewMemoryBackplane())
+}
+
+func TestHubServer_RedisBackplane(t *testing.T) {
+	rs, err := signalrtest.NewRedisServer()
+	require.NoError(t, err)
+	defer rs.Close()
+
+	backplane, err := redisbackplane.New(rs.Addr)
+	require.NoError(t, err)
+	defer func() {
+		_ = backplane.Close()
+	}()
+
+	testScaleOut(t, backplane)
+}
+
+func TestHubServer_BackplaneKeysEscapeHubNames(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backplane := signalr.NewMemoryBackplane()
+	hub, ts := newChatHub(t, signalr.HubServerWithBackplane("h", backplane))
+	defer ts.Close()
+	defer hub.server.Close()
+	other, otherTS := newChatHub(t, signalr.HubServerWithBackplane("h:users:u", backplane))
+	defer otherTS.Close()
+	defer other.server.Close()
+
+	conn, id := connectHub(ctx, t, hub, ts.URL+"/chat?user=u")
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	exists, err := hub.server.UserExists(ctx, "u")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// the connections of user u of hub h are not the connections of hub h:users:u
+	exists, err = other.server.ConnectionExists(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// testScaleOut runs two hub servers sharing the backplane and checks messages, groups and presence span them
+func testScaleOut(t *testing.T, backplane signalr.Backplane) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hubA, tsA := newChatHub(t, signalr.HubServerWithBackplane("chat", backplane))
+	defer tsA.Close()
+	defer hubA.server.Close()
+	hubB, tsB := newChatHub(t, signalr.HubServerWithBackplane("chat", backplane))
+	defer tsB.Close()
+	defer hubB.server.Close()
+
+	alice, aliceID := connectHub(ctx, t, hubA, tsA.URL+"/chat?user=alice")
+	defer func() {
+		_ = alice.Close(websocket.StatusNormalClosure, "")
+	}()
+	bob, bobID := connectHub(ctx, t, hubB, tsB.URL+"/chat?user=bob")
+	defer func() {
+		_ = bob.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	for _, server := range []*signalr.HubServer{hubA.server, hubB.server} {
+		exists, err := server.ConnectionExists(ctx, aliceID)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		exists, err = server.UserExists(ctx, "bob")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	greet, err := signalr.NewInvocationMessage("Greet", "hello")
+	require.NoError(t, err)
+	expected := []map[string]interface{}{{"type": 1.0, "target": "Greet", "arguments": []interface{}{"hello"}}}
+
+	// broadcasts reach the connections of every node
+	require.NoError(t, hubA.server.Broadcast(ctx, greet))
+	assert.Equal(t, expected, readHub(ctx, t, alice))
+	assert.Equal(t, expected, readHub(ctx, t, bob))
+
+	// a group joined on one node receives messages sent from the other
+	writeHub(ctx, t, bob, map[string]interface{}{"type": 1, "invocationId": "1", "target": "Join", "arguments": []string{"room"}})
+	assert.Equal(t, []map[string]interface{}{{"type": 3.0, "invocationId": "1"}}, readHub(ctx, t, bob))
+	exists, err := hubA.server.GroupExists(ctx, "room")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, hubA.server.AddUserToGroup(ctx, "room", "alice"))
+	require.NoError(t, hubB.server.SendToGroup(ctx, greet, "room"))
+	assert.Equal(t, expected, readHub(ctx, t, alice))
+	assert.Equal(t, expected, readHub(ctx, t, bob))
+
+	require.NoError(t, hubB.server.SendToUser(ctx, greet, "alice"))
+	assert.Equal(t, expected, readHub(ctx, t, alice))
+
+	require.NoError(t, hubA.server.SendToConnection(ctx, greet, bobID))
+	assert.Equal(t, expected, readHub(ctx, t, bob))
+
+	// connections close from any node and leave the shared state as they disconnect
+	require.NoError(t, hubA.server.CloseConnection(ctx, bobID, "bye"))
+	assert.Equal(t, []map[string]interface{}{{"type": 7.0, "error": "bye"}}, readHub(ctx, t, bob))
+	assert.NoError(t, <-hubB.disconnected)
+
+	exists, err = hubA.server.UserExists(ctx, "bob")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// the group remains as alice is still a member
+	exists, err = hubB.server.GroupExists(ctx, "room")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+// connectHub opens a WebSocket connection to the hub and completes the handshake, returning the connection ID
+func connectHub(ctx context.Context, t *testing.T, hub *chatHub, url string) (*websocket.Conn, string) {
+	conn := dialHub(ctx, t, url)
+	writeHub(ctx, t, conn, map[string]interface{}{"protocol": "json", "version": 1})
+	require.Equal(t, []map[string]interface{}{{}}, readHub(ctx, t, conn))
+	connected := <-hub.connected
+	return conn, connected.ID
+}
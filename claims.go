@@ -0,0 +1,155 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type (
+	// ClientClaims describe the user principal a client connects to the service as. Roles and Claims are added to the
+	// client's access token so the service, and any upstream handlers, see the same principal as the application
+	// which issued the token.
+	ClientClaims struct {
+		// Roles are added as role claims
+		Roles []string
+		// Groups are joined on the client's behalf through the REST API: `Listen` adds its connection to them as soon as
+		// it is established, and `GenerateClientAccess` adds the user to them before issuing access
+		Groups []string
+		// Claims are added to the access token as is; they cannot replace the registered claims of the token
+		Claims map[string]interface{}
+	}
+
+	// ClaimsBuilder builds the claims for the access token of a user each time a new token is issued
+	ClaimsBuilder func(ctx context.Context, userID string) (*ClientClaims, error)
+
+	// clientTokenRequest describes a client access token to issue
+	clientTokenRequest struct {
+		Audience      string
+		UserID        string
+		ExpiresAfter  time.Duration
+		NotBeforeSkew time.Duration
+		Claims        *ClientClaims
+	}
+)
+
+const (
+	// NameIDClaim is the claim identifying the user of a client connection
+	NameIDClaim = "nameid"
+	// RoleClaim is the claim carrying the roles of a client connection
+	RoleClaim = "role"
+)
+
+var (
+	registeredClaims = map[string]bool{
+		"aud":       true,
+		"exp":       true,
+		"iat":       true,
+		"nbf":       true,
+		NameIDClaim: true,
+	}
+)
+
+// ClientWithClaims configures the client to connect with the provided roles, groups and claims
+func ClientWithClaims(claims ClientClaims) ClientOption {
+	return ClientWithClaimsBuilder(func(context.Context, string) (*ClientClaims, error) {
+		return &claims, nil
+	})
+}
+
+// ClientWithClaimsBuilder configures the client to build the roles, groups and claims it connects with each time a new
+// access token is issued
+func ClientWithClaimsBuilder(builder ClaimsBuilder) ClientOption {
+	return func(client *Client) error {
+		if builder == nil {
+			return errors.New("claims builder must not be nil")
+		}
+		client.claimsBuilder = builder
+		return nil
+	}
+}
+
+// buildClaims returns the claims configured for the user, if any
+func (c *Client) buildClaims(ctx context.Context, userID string) (*ClientClaims, error) {
+	if c.claimsBuilder == nil {
+		return nil, nil
+	}
+	return c.claimsBuilder(ctx, userID)
+}
+
+// merge returns the union of the claims without modifying either; claims in other replace claims of the same name
+func (cc *ClientClaims) merge(other *ClientClaims) *ClientClaims {
+	if cc == nil {
+		return other
+	}
+	if other == nil {
+		return cc
+	}
+
+	merged := &ClientClaims{
+		Roles:  append(append([]string(nil), cc.Roles...), other.Roles...),
+		Groups: append(append([]string(nil), cc.Groups...), other.Groups...),
+	}
+
+	if len(cc.Claims) > 0 || len(other.Claims) > 0 {
+		merged.Claims = make(map[string]interface{}, len(cc.Claims)+len(other.Claims))
+		for name, value := range cc.Claims {
+			merged.Claims[name] = value
+		}
+		for name, value := range other.Claims {
+			merged.Claims[name] = value
+		}
+	}
+	return merged
+}
+
+// cacheKey returns a stable representation of the claims so tokens for different claims are cached separately
+func (cc *ClientClaims) cacheKey() (string, error) {
+	if cc == nil {
+		return "", nil
+	}
+
+	bits, err := json.Marshal(cc)
+	if err != nil {
+		return "", err
+	}
+	return string(bits), nil
+}
+
+// hasTokenClaims returns true if the claims add anything to the access token
+func (cc *ClientClaims) hasTokenClaims() bool {
+	return cc != nil && (len(cc.Roles) > 0 || len(cc.Claims) > 0)
+}
+
+func generateToken(key string, req clientTokenRequest) (string, error) {
+	now := time.Now().UTC()
+	claims := jwt.MapClaims{}
+	if req.Claims != nil {
+		for name, value := range req.Claims.Claims {
+			if registeredClaims[name] {
+				return "", errors.New("the " + name + " claim is set by the client and cannot be provided as a custom claim")
+			}
+			claims[name] = value
+		}
+
+		if len(req.Claims.Roles) > 0 {
+			claims[RoleClaim] = req.Claims.Roles
+		}
+	}
+
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Add(-req.NotBeforeSkew).Unix()
+	claims["exp"] = now.Add(req.ExpiresAfter).Unix()
+	if req.Audience != "" {
+		claims["aud"] = req.Audience
+	}
+	if req.UserID != "" {
+		claims[NameIDClaim] = req.UserID
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(key))
+}
@@ -0,0 +1,103 @@
+package signalr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_TokenIncludesClaims(t *testing.T) {
+	client, err := NewClient("Endpoint=https://foo.service.signalr.net;AccessKey=foo", "hub1",
+		ClientWithName("user1"),
+		ClientWithClaims(ClientClaims{
+			Roles:  []string{"admin", "reader"},
+			Groups: []string{"group1"},
+			Claims: map[string]interface{}{"tenant": "contoso"},
+		}))
+	require.NoError(t, err)
+
+	endpoint := client.endpoints[0]
+	token, claims, err := client.clientToken(context.Background(), endpoint, client.getWssAudience(endpoint))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group1"}, claims.Groups)
+
+	parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return []byte("foo"), nil })
+	require.NoError(t, err)
+	mapClaims := parsed.Claims.(jwt.MapClaims)
+	assert.Equal(t, "user1", mapClaims[NameIDClaim])
+	assert.Equal(t, []interface{}{"admin", "reader"}, mapClaims[RoleClaim])
+	assert.Equal(t, "contoso", mapClaims["tenant"])
+	assert.Equal(t, "https://foo.service.signalr.net/client/?hub=hub1", mapClaims["aud"])
+}
+
+func TestClient_ClaimsBuilderPerToken(t *testing.T) {
+	tenant := "contoso"
+	client, err := NewClient("Endpoint=https://foo.service.signalr.net;AccessKey=foo", "hub1",
+		ClientWithClaimsBuilder(func(_ context.Context, userID string) (*ClientClaims, error) {
+			return &ClientClaims{Claims: map[string]interface{}{"tenant": tenant}}, nil
+		}))
+	require.NoError(t, err)
+
+	endpoint := client.endpoints[0]
+	audience := client.getWssAudience(endpoint)
+	first, _, err := client.clientToken(context.Background(), endpoint, audience)
+	require.NoError(t, err)
+	cached, _, err := client.clientToken(context.Background(), endpoint, audience)
+	require.NoError(t, err)
+	assert.Equal(t, first, cached)
+
+	tenant = "fabrikam"
+	changed, _, err := client.clientToken(context.Background(), endpoint, audience)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, changed)
+}
+
+func TestClientWithClaimsBuilder_RejectsNil(t *testing.T) {
+	_, err := NewClient("Endpoint=https://foo.service.signalr.net;AccessKey=foo", "hub1", ClientWithClaimsBuilder(nil))
+	assert.EqualError(t, err, "claims builder must not be nil")
+}
+
+func TestGenerateToken_RejectsRegisteredClaims(t *testing.T) {
+	_, err := generateToken("foo", clientTokenRequest{
+		Audience:     "aud",
+		ExpiresAfter: time.Hour,
+		Claims:       &ClientClaims{Claims: map[string]interface{}{"exp": 0}},
+	})
+	assert.Error(t, err)
+}
+
+func TestServiceManager_TokenLifetimeAndSkew(t *testing.T) {
+	sm, err := NewServiceManager("Endpoint=https://foo.service.signalr.net;AccessKey=foo", "hub1",
+		ServiceManagerWithTokenLifetime(10*time.Minute),
+		ServiceManagerWithNotBeforeSkew(30*time.Second))
+	require.NoError(t, err)
+
+	now := time.Now().Unix()
+	claimsOf := func(token string) jwt.MapClaims {
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		require.NoError(t, err)
+		return parsed.Claims.(jwt.MapClaims)
+	}
+
+	access, err := sm.tokenProvider.GetToken(context.Background(), "aud")
+	require.NoError(t, err)
+	claims := claimsOf(access.Token)
+	assert.InDelta(t, now+600, claims["exp"], 2)
+	assert.InDelta(t, now-30, claims["nbf"], 2)
+
+	override, err := sm.tokenProvider.GetToken(WithTokenLifetime(context.Background(), time.Minute), "aud")
+	require.NoError(t, err)
+	assert.InDelta(t, now+60, claimsOf(override.Token)["exp"], 2)
+
+	client, err := sm.GenerateClientToken(context.Background(), "user1", 0)
+	require.NoError(t, err)
+	assert.InDelta(t, now+600, claimsOf(client)["exp"], 2)
+
+	_, err = NewServiceManager("Endpoint=https://foo.service.signalr.net;AccessKey=foo", "hub1",
+		ServiceManagerWithNotBeforeSkew(-time.Second))
+	assert.Error(t, err)
+}
@@ -11,11 +11,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"nhooyr.io/websocket"
 )
@@ -23,12 +23,33 @@ import (
 type (
 	// Client represents a bidirectional connection to Azure SignalR
 	Client struct {
-		name          string
-		hubName       string
-		audType       audienceType
-		parsedConnStr *ParsedConnString
-		nMutex        sync.RWMutex
-		negotiateRes  *negotiateResponse
+		name                 string
+		hubName              string
+		audType              audienceType
+		endpoints            []*ServiceEndpoint
+		managers             map[*ServiceEndpoint]*ServiceManager
+		nMutex               sync.RWMutex
+		negotiateRes         *negotiateResponse
+		endpoint             *ServiceEndpoint
+		router               EndpointRouter
+		tokenProvider        TokenProvider
+		maxReconnectAttempts int
+		serverTimeout        time.Duration
+		concurrency          int
+		claimsBuilder        ClaimsBuilder
+		managerOpts          []ServiceManagerOption
+		interceptors         []Interceptor
+		errorHandler         InvocationErrorHandler
+	}
+
+	// InvocationErrorHandler decides how `Listen` treats an error returned by its handler, including a
+	// `HandlerPanicError` when the handler panics. Returning an error stops `Listen` with that error, while returning
+	// nil continues listening.
+	InvocationErrorHandler func(ctx context.Context, msg *InvocationMessage, err error) error
+
+	// reconnectError wraps an error which ended a connection the client should reconnect after
+	reconnectError struct {
+		err error
 	}
 
 	// ClientOption provides a way to configure a client at time of construction
@@ -48,11 +69,6 @@ type (
 	audienceType   string
 	transportTypes string
 
-	signalrCliams struct {
-		jwt.StandardClaims
-		NameID string `json:"nameid,omitempty"`
-	}
-
 	handshakeRequest struct {
 		Protocol string `json:"protocol"`
 		Version  int    `json:"version"`
@@ -72,13 +88,18 @@ type (
 		Target       string            `json:"target"`
 		Arguments    []json.RawMessage `json:"arguments"`
 		Error        string            `json:"error,omitempty"`
+		// AllowReconnect is set on a close message when the client may reconnect
+		AllowReconnect bool `json:"allowReconnect,omitempty"`
 	}
 )
 
 const (
 	messageTerminator byte = 0x1E
+)
 
-	invocationMessageType messageType = iota
+// hub protocol message types, numbered from 1
+const (
+	invocationMessageType messageType = iota + 1
 	streamItemMessageType
 	completionMessageType
 	streamInvocationMessageType
@@ -87,11 +108,27 @@ const (
 	closeMessageType
 )
 
+const (
+	// keepAliveInterval is how often the client pings the service, as the service closes connections which have not
+	// sent anything within 30 seconds
+	keepAliveInterval = 15 * time.Second
+
+	// DefaultServerTimeout is how long the client waits for any message from the service before considering the
+	// connection dropped
+	DefaultServerTimeout = 30 * time.Second
+
+	// DefaultMaxReconnectAttempts is the number of consecutive failed attempts to reconnect `Listen` makes before
+	// returning an error
+	DefaultMaxReconnectAttempts = 5
+)
+
 var (
 	serverAudienceType audienceType = "server"
 	clientAudienceType audienceType = "client"
 
-	websocketTransportType transportTypes = "WebSockets"
+	websocketTransportType        transportTypes = "WebSockets"
+	serverSentEventsTransportType transportTypes = "ServerSentEvents"
+	longPollingTransportType      transportTypes = "LongPolling"
 )
 
 // ClientWithName configures a SignalR client to use a specific name for addressing the client individually
@@ -104,18 +141,125 @@ func ClientWithName(name string) ClientOption {
 	}
 }
 
+// ClientWithMaxReconnectAttempts configures the number of consecutive failed attempts `Listen` makes to reconnect
+// after the connection drops before returning an error. Zero disables reconnecting.
+func ClientWithMaxReconnectAttempts(attempts int) ClientOption {
+	return func(client *Client) error {
+		if attempts < 0 {
+			return errors.New("max reconnect attempts must not be negative")
+		}
+		client.maxReconnectAttempts = attempts
+		return nil
+	}
+}
+
+// ClientWithServerTimeout configures how long `Listen` waits for any message from the service, including its pings,
+// before considering the connection dropped and reconnecting
+func ClientWithServerTimeout(timeout time.Duration) ClientOption {
+	return func(client *Client) error {
+		if timeout <= 0 {
+			return errors.New("server timeout must be positive")
+		}
+		client.serverTimeout = timeout
+		return nil
+	}
+}
+
+// ClientWithBatchConcurrency configures the maximum number of concurrent requests used when fanning out a multi-user
+// or multi-group send, including the sends of each endpoint's service manager
+func ClientWithBatchConcurrency(concurrency int) ClientOption {
+	return func(client *Client) error {
+		if concurrency < 1 {
+			return errors.New("batch concurrency must be at least 1")
+		}
+		client.concurrency = concurrency
+		client.managerOpts = append(client.managerOpts, ServiceManagerWithBatchConcurrency(concurrency))
+		return nil
+	}
+}
+
+// ClientWithTokenLifetime configures how long the access tokens the client signs with the access keys of its endpoints
+// are valid for. Use `WithTokenLifetime` to override the lifetime for a single operation.
+func ClientWithTokenLifetime(lifetime time.Duration) ClientOption {
+	return func(client *Client) error {
+		if lifetime <= 0 {
+			return errors.New("token lifetime must be positive")
+		}
+		client.managerOpts = append(client.managerOpts, ServiceManagerWithTokenLifetime(lifetime))
+		return nil
+	}
+}
+
+// ClientWithNotBeforeSkew configures how far the not-before time of the access tokens the client signs with the access
+// keys of its endpoints is backdated to tolerate clock skew between the host and the service
+func ClientWithNotBeforeSkew(skew time.Duration) ClientOption {
+	return func(client *Client) error {
+		if skew < 0 {
+			return errors.New("not-before skew must not be negative")
+		}
+		client.managerOpts = append(client.managerOpts, ServiceManagerWithNotBeforeSkew(skew))
+		return nil
+	}
+}
+
+// ClientWithInterceptors adds interceptors run around every invocation `Listen` dispatches to its handler. Interceptors
+// run in the order they are added.
+func ClientWithInterceptors(interceptors ...Interceptor) ClientOption {
+	return func(client *Client) error {
+		for _, interceptor := range interceptors {
+			if interceptor == nil {
+				return errors.New("interceptor must not be nil")
+			}
+		}
+		client.interceptors = append(client.interceptors, interceptors...)
+		return nil
+	}
+}
+
+// ClientWithErrorHandler configures how `Listen` treats errors returned by its handler. By default, the errors of
+// invocations whose caller awaits the result are sent back to the caller and listening continues, while any other
+// error stops `Listen`.
+func ClientWithErrorHandler(handler InvocationErrorHandler) ClientOption {
+	return func(client *Client) error {
+		if handler == nil {
+			return errors.New("error handler must not be nil")
+		}
+		client.errorHandler = handler
+		return nil
+	}
+}
+
 // NewClient constructs a new client given a set of construction options
 func NewClient(connStr string, hubName string, opts ...ClientOption) (*Client, error) {
-	parsed, err := ParseConnectionString(connStr)
+	endpoint, err := NewServiceEndpoint("", EndpointTypePrimary, connStr)
 	if err != nil {
 		return nil, err
 	}
 
+	return NewClientWithEndpoints(hubName, []*ServiceEndpoint{endpoint}, opts...)
+}
+
+// NewClientWithEndpoints constructs a new client which routes between several SignalR service instances. By default
+// REST calls are sent to the healthy primary endpoints and fail over to the secondary endpoints; `Listen` connects to
+// the first healthy primary, or a healthy secondary if no primary is healthy. Use `ClientWithEndpointRouter` to
+// customize the routing.
+func NewClientWithEndpoints(hubName string, endpoints []*ServiceEndpoint, opts ...ClientOption) (*Client, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("at least one endpoint is required")
+	}
+
 	client := &Client{
-		hubName:       hubName,
-		parsedConnStr: parsed,
-		audType:       clientAudienceType,
-		name:          uuid.Must(uuid.NewRandom()).String(),
+		hubName:   hubName,
+		audType:   clientAudienceType,
+		name:      uuid.Must(uuid.NewRandom()).String(),
+		endpoints: endpoints,
+		managers:  make(map[*ServiceEndpoint]*ServiceManager, len(endpoints)),
+		router:    DefaultEndpointRouter{},
+
+		maxReconnectAttempts: DefaultMaxReconnectAttempts,
+		serverTimeout:        DefaultServerTimeout,
+		concurrency:          DefaultBatchConcurrency,
+		errorHandler:         stopOnUnawaitedError,
 	}
 
 	for _, opt := range opts {
@@ -124,23 +268,84 @@ func NewClient(connStr string, hubName string, opts ...ClientOption) (*Client, e
 		}
 	}
 
+	managerOpts := client.managerOpts
+	if client.tokenProvider != nil {
+		// wrap the provider once so every endpoint shares the same token cache
+		managerOpts = append(managerOpts, ServiceManagerWithTokenProvider(newCachingTokenProvider(client.tokenProvider)))
+	}
+
+	names := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		if names[endpoint.GetName()] {
+			return nil, errors.New("endpoint names must be unique; found duplicate " + endpoint.GetName())
+		}
+		names[endpoint.GetName()] = true
+
+		manager, err := newServiceManager(endpoint.parsedConnStr, hubName, managerOpts...)
+		if err != nil {
+			return nil, err
+		}
+		client.managers[endpoint] = manager
+	}
+
 	return client, nil
 }
 
-// Listen will start the WebSocket connection for the client
+// Listen will start the WebSocket connection for the client. If the connection drops or the service closes it and
+// allows reconnecting, the client negotiates and connects again using a refreshed access token. Listen returns nil
+// when the context is done.
 func (c *Client) Listen(ctx context.Context, handler Handler) error {
-	err := c.negotiateOnce(ctx)
+	invoker := chainInterceptors(handler, c.interceptors)
+	started := false
+	attempts := 0
+	for {
+		connected, err := c.listen(ctx, handler, invoker, &started)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		rErr, ok := err.(reconnectError)
+		if !ok {
+			return err
+		}
+
+		if connected {
+			attempts = 0
+		}
+		attempts++
+		if attempts > c.maxReconnectAttempts {
+			return rErr.err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay(attempts)):
+		}
+	}
+}
+
+// listen connects to the service and dispatches messages to the handler until the connection ends. Errors which
+// should cause the client to reconnect are returned as a `reconnectError`; connected reports whether the handshake
+// completed.
+func (c *Client) listen(ctx context.Context, handler Handler, invoker Invoker, started *bool) (connected bool, err error) {
+	if *started {
+		// connection IDs are single use, so reconnecting requires a new negotiation
+		c.resetNegotiation()
+	}
+
+	endpoint, negotiateRes, err := c.negotiateOnce(ctx)
 	if err != nil {
-		return err
+		return false, c.reconnectable(*started, err)
 	}
 
-	audience := c.getWssAudience()
-	token, err := c.generateToken(audience, 2*time.Hour)
+	audience := c.getWssAudience(endpoint)
+	token, claims, err := c.clientToken(ctx, endpoint, audience)
 	if err != nil {
-		return err
+		return false, c.reconnectable(*started, err)
 	}
 
-	conn, resp, err := websocket.Dial(ctx, c.getWssURI(), websocket.DialOptions{
+	conn, resp, err := websocket.Dial(ctx, c.getWssURI(endpoint, negotiateRes), websocket.DialOptions{
 		HTTPHeader: http.Header{
 			"Authorization": []string{"Bearer " + token},
 		},
@@ -153,151 +358,211 @@ func (c *Client) Listen(ctx context.Context, handler Handler) error {
 	}
 
 	if err != nil {
-		return err
+		if isUnhealthyEndpointError(ctx, err) && resp == nil {
+			endpoint.setHealthy(false)
+		}
+		return false, c.reconnectable(*started, err)
 	}
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
 
 	err = c.handshake(ctx, conn)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	if claims != nil {
+		for _, group := range claims.Groups {
+			if err := c.managers[endpoint].AddConnectionToGroup(ctx, group, negotiateRes.ConnectionID); err != nil {
+				return false, c.reconnectable(*started, err)
+			}
+		}
 	}
 
 	select {
 	case <-ctx.Done():
+		return true, nil
 	default:
+	}
+
+	if !*started {
+		*started = true
 		if h, ok := handler.(NotifiedHandler); ok {
 			h.OnStart()
 		}
+	}
 
-		for {
-			bits, err := readConn(ctx, conn)
-			if err != nil {
-				if err.Error() == "failed to get reader: context canceled" {
-					return nil
-				}
-				return err
+	keepAliveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go c.keepAlive(keepAliveCtx, conn, endpoint, audience)
+
+	for {
+		bits, err := readConn(ctx, conn, c.serverTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return true, nil
 			}
+			return true, reconnectError{err: err}
+		}
 
+		for _, record := range splitRecords(bits) {
 			var msg InvocationMessage
-			err = json.Unmarshal(bits, &msg)
+			err = json.Unmarshal(record, &msg)
 			if err != nil {
-				return err
+				return true, err
 			}
 
-			//fmt.Println(string(bits))
 			switch msg.Type {
 			case pingMessageType:
 				// nop
 			case invocationMessageType:
-				return dispatch(ctx, handler, &msg)
+				if err := c.dispatch(ctx, conn, invoker, &msg); err != nil {
+					return true, err
+				}
 			case streamInvocationMessageType, streamItemMessageType, cancelInvocationMessageType, completionMessageType:
-				return errors.New("unhandled InvocationMessage type: " + string(msg.Type))
+				return true, fmt.Errorf("unhandled InvocationMessage type: %d", msg.Type)
 			case closeMessageType:
-				return conn.Close(websocket.StatusNormalClosure, "received close message from SignalR service")
+				if msg.AllowReconnect {
+					return true, reconnectError{err: fmt.Errorf("service closed the connection: %s", msg.Error)}
+				}
+				return true, conn.Close(websocket.StatusNormalClosure, "received close message from SignalR service")
 			}
+		}
+	}
+}
 
+// dispatch calls the handler with an invocation from the service. If the caller awaits the result of the invocation,
+// the result, or the error the handler failed with, is sent back as a completion. Errors are passed to the error
+// handler, which decides whether listening stops.
+func (c *Client) dispatch(ctx context.Context, conn *websocket.Conn, invoker Invoker, msg *InvocationMessage) error {
+	result, hasResult, invokeErr := invoker(ctx, msg)
+	if msg.InvocationID != "" {
+		completion, err := newCompletionMessage(msg, result, hasResult, invokeErr)
+		if err != nil {
+			// the result could not be encoded, so the caller is told the invocation failed
+			invokeErr = err
+			completion, _ = newCompletionMessage(msg, nil, false, err)
+		}
+
+		if err := writeMessage(ctx, conn, completion); err != nil {
+			return err
 		}
 	}
 
-	return nil
+	if invokeErr == nil {
+		return nil
+	}
+	return c.errorHandler(ctx, msg, invokeErr)
+}
+
+// stopOnUnawaitedError is the default error handler, which stops `Listen` unless the error was sent back to the
+// caller of the invocation
+func stopOnUnawaitedError(_ context.Context, msg *InvocationMessage, err error) error {
+	if msg.InvocationID != "" {
+		return nil
+	}
+	return err
+}
+
+// keepAlive pings the service so it does not time out an idle connection, and keeps the access token used to
+// reconnect refreshed ahead of its expiry
+func (c *Client) keepAlive(ctx context.Context, conn *websocket.Conn, endpoint *ServiceEndpoint, audience string) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := writeMessage(ctx, conn, InvocationMessage{Type: pingMessageType}); err != nil {
+				return
+			}
+			_, _, _ = c.clientToken(ctx, endpoint, audience)
+		}
+	}
+}
+
+// reconnectable marks errors connecting to the service as worth retrying once the client has connected at least once
+func (c *Client) reconnectable(started bool, err error) error {
+	if started {
+		return reconnectError{err: err}
+	}
+	return err
+}
+
+// reconnectDelay backs off exponentially from 250ms to at most 8s between reconnect attempts
+func reconnectDelay(attempt int) time.Duration {
+	delay := 250 * time.Millisecond
+	for i := 1; i < attempt && delay < 8*time.Second; i++ {
+		delay *= 2
+	}
+	return delay
 }
 
 // BroadcastAll will send a broadcast `InvocationMessage` to all listening to the hub
 func (c *Client) BroadcastAll(ctx context.Context, msg *InvocationMessage) error {
-	return c.SendInvocation(ctx, c.getBroadcastURI(), msg)
+	targets := c.router.GetEndpointsForBroadcast(ctx, c.endpoints)
+	return c.route(ctx, targets, func(ctx context.Context, sm *ServiceManager) error {
+		return sm.Broadcast(ctx, msg)
+	})
 }
 
 // BroadcastGroup will send a broadcast `InvocationMessage` to all listening to the hub group
 func (c *Client) BroadcastGroup(ctx context.Context, msg *InvocationMessage, groupName string) error {
-	return c.SendInvocation(ctx, c.getSendToGroupURI(groupName), msg)
+	targets := c.router.GetEndpointsForGroup(ctx, groupName, c.endpoints)
+	return c.route(ctx, targets, func(ctx context.Context, sm *ServiceManager) error {
+		return sm.SendToGroup(ctx, msg, groupName)
+	})
 }
 
 // SendToUser will send a `InvocationMessage` to a particular user
 func (c *Client) SendToUser(ctx context.Context, msg *InvocationMessage, userID string) error {
-	return c.SendInvocation(ctx, c.getSendToUserURI(userID), msg)
+	targets := c.router.GetEndpointsForUser(ctx, userID, c.endpoints)
+	return c.route(ctx, targets, func(ctx context.Context, sm *ServiceManager) error {
+		return sm.SendToUser(ctx, msg, userID)
+	})
 }
 
-// AddUserToGroup will add a userID to a SignalR group
-func (c *Client) AddUserToGroup(ctx context.Context, groupName string, userID string) error {
-	req, err := http.NewRequest(http.MethodPut, c.getGroupUserURI(groupName, userID), nil)
-	if err != nil {
-		return err
-	}
-
-	res, err := c.do(ctx, req)
-	defer closeRes(res)
-	if err != nil {
-		return err
-	}
-
-	bodyBits, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
+// SendToUsers will send a `InvocationMessage` to each of the users, returning a `BatchSendError` reporting each user
+// the send failed for
+func (c *Client) SendToUsers(ctx context.Context, msg *InvocationMessage, userIDs []string) error {
+	return fanOut(ctx, c.concurrency, userIDs, func(ctx context.Context, userID string) error {
+		return c.SendToUser(ctx, msg, userID)
+	})
+}
 
-	if res.StatusCode > 399 {
-		return SendFailureError{
-			StatusCode: res.StatusCode,
-			Body:       string(bodyBits),
-		}
-	}
+// SendToGroups will send a broadcast `InvocationMessage` to each of the groups, returning a `BatchSendError` reporting
+// each group the send failed for
+func (c *Client) SendToGroups(ctx context.Context, msg *InvocationMessage, groupNames []string) error {
+	return fanOut(ctx, c.concurrency, groupNames, func(ctx context.Context, groupName string) error {
+		return c.BroadcastGroup(ctx, msg, groupName)
+	})
+}
 
-	return nil
+// AddUserToGroup will add a userID to a SignalR group
+func (c *Client) AddUserToGroup(ctx context.Context, groupName string, userID string) error {
+	targets := c.router.GetEndpointsForUser(ctx, userID, c.endpoints)
+	return c.route(ctx, targets, func(ctx context.Context, sm *ServiceManager) error {
+		return sm.AddUserToGroup(ctx, groupName, userID)
+	})
 }
 
 // RemoveUserFromGroup will remove a userID from a SignalR group
 func (c *Client) RemoveUserFromGroup(ctx context.Context, groupName string, userID string) error {
-	req, err := http.NewRequest(http.MethodDelete, c.getGroupUserURI(groupName, userID), nil)
-	if err != nil {
-		return err
-	}
-
-	res, err := c.do(ctx, req)
-	defer closeRes(res)
-	if err != nil {
-		return err
-	}
-
-	bodyBits, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
-
-	if res.StatusCode > 399 {
-		return SendFailureError{
-			StatusCode: res.StatusCode,
-			Body:       string(bodyBits),
-		}
-	}
-
-	return nil
+	targets := c.router.GetEndpointsForUser(ctx, userID, c.endpoints)
+	return c.route(ctx, targets, func(ctx context.Context, sm *ServiceManager) error {
+		return sm.RemoveUserFromGroup(ctx, groupName, userID)
+	})
 }
 
 // RemoveUserFromAllGroups will remove a user from all groups
 func (c *Client) RemoveUserFromAllGroups(ctx context.Context, userID string) error {
-	req, err := http.NewRequest(http.MethodDelete, c.getUsersGroupsURI(userID), nil)
-	if err != nil {
-		return err
-	}
-
-	res, err := c.do(ctx, req)
-	defer closeRes(res)
-	if err != nil {
-		return err
-	}
-
-	bodyBits, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
-
-	if res.StatusCode > 399 {
-		return SendFailureError{
-			StatusCode: res.StatusCode,
-			Body:       string(bodyBits),
-		}
-	}
-
-	return nil
+	targets := c.router.GetEndpointsForUser(ctx, userID, c.endpoints)
+	return c.route(ctx, targets, func(ctx context.Context, sm *ServiceManager) error {
+		return sm.RemoveUserFromAllGroups(ctx, userID)
+	})
 }
 
 // SendInvocation will send an `InvocationMessage` to the hub
@@ -312,20 +577,23 @@ func (c *Client) SendInvocation(ctx context.Context, uri string, msg *Invocation
 		return err
 	}
 
-	token, err := c.generateToken(uri, 2*time.Hour)
+	endpoint, err := c.endpointForURI(ctx, uri)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
+	token, err := c.managers[endpoint].tokenProvider.GetToken(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.Token)
 	req.Header.Set("Content-Type", "application/json")
-	req.WithContext(ctx)
 	client := newHTTPClient()
-	res, err := client.Do(req)
+	res, err := client.Do(req.WithContext(ctx))
 	defer closeRes(res)
-
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 
 	bodyBits, err := ioutil.ReadAll(res.Body)
@@ -353,21 +621,10 @@ func (c *Client) GetHub() string {
 	return c.hubName
 }
 
-func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
-	token, err := c.generateToken(req.URL.String(), 2*time.Hour)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	req.WithContext(ctx)
-	client := newHTTPClient()
-	return client.Do(req)
-}
-
-func readConn(ctx context.Context, conn *websocket.Conn) ([]byte, error) {
-	readerCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// readConn reads the next message from the connection. The service pings every 15 seconds, so a connection which has
+// not sent anything within the server timeout is considered dropped.
+func readConn(ctx context.Context, conn *websocket.Conn, timeout time.Duration) ([]byte, error) {
+	readerCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	_, reader, err := conn.Reader(readerCtx)
@@ -375,25 +632,24 @@ func readConn(ctx context.Context, conn *websocket.Conn) ([]byte, error) {
 		return nil, err
 	}
 
-	bits, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-
-	if bits[len(bits)-1] == messageTerminator {
-		bits = bits[0 : len(bits)-1]
-	}
-
-	return bits, nil
+	return ioutil.ReadAll(reader)
 }
 
-func (c *Client) handshake(ctx context.Context, conn *websocket.Conn) error {
-	hsReq := handshakeRequest{
-		Protocol: "json",
-		Version:  1,
+// splitRecords splits a message into the terminated records it contains, as the service may batch several records
+// into a single message
+func splitRecords(bits []byte) [][]byte {
+	var records [][]byte
+	for _, record := range bytes.Split(bits, []byte{messageTerminator}) {
+		if len(record) > 0 {
+			records = append(records, record)
+		}
 	}
+	return records
+}
 
-	bits, err := json.Marshal(hsReq)
+// writeMessage writes a single terminated JSON record to the connection
+func writeMessage(ctx context.Context, conn *websocket.Conn, msg interface{}) error {
+	bits, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
@@ -403,12 +659,20 @@ func (c *Client) handshake(ctx context.Context, conn *websocket.Conn) error {
 		return err
 	}
 
-	_, err = wrCloser.Write(append(bits, messageTerminator))
-	if err != nil {
+	if _, err := wrCloser.Write(append(bits, messageTerminator)); err != nil {
 		return err
 	}
 
-	if err := wrCloser.Close(); err != nil {
+	return wrCloser.Close()
+}
+
+func (c *Client) handshake(ctx context.Context, conn *websocket.Conn) error {
+	hsReq := handshakeRequest{
+		Protocol: "json",
+		Version:  1,
+	}
+
+	if err := writeMessage(ctx, conn, hsReq); err != nil {
 		return err
 	}
 
@@ -417,17 +681,18 @@ func (c *Client) handshake(ctx context.Context, conn *websocket.Conn) error {
 		return err
 	}
 
-	bits, err = ioutil.ReadAll(resp)
+	bits, err := ioutil.ReadAll(resp)
 	if err != nil {
 		return err
 	}
 
-	if bits[len(bits)-1] == messageTerminator {
-		bits = bits[0 : len(bits)-1]
+	records := splitRecords(bits)
+	if len(records) == 0 {
+		return errors.New("received an empty handshake response")
 	}
 
 	var hsRes handshakeResponse
-	if err := json.Unmarshal(bits, &hsRes); err != nil {
+	if err := json.Unmarshal(records[0], &hsRes); err != nil {
 		return err
 	}
 
@@ -437,53 +702,59 @@ func (c *Client) handshake(ctx context.Context, conn *websocket.Conn) error {
 	return nil
 }
 
-func (c *Client) generateToken(audience string, expiresAfter time.Duration) (string, error) {
-	now := time.Now().UTC()
-	claims := signalrCliams{
-		StandardClaims: jwt.StandardClaims{
-			IssuedAt:  now.Unix(),
-			NotBefore: now.Unix(),
-			Audience:  audience,
-			ExpiresAt: now.Add(expiresAfter).Unix(),
-		},
-		NameID: c.name,
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(c.parsedConnStr.Key))
-}
-
-func (c *Client) getWssURI() string {
-	wssBaseURI := strings.Replace(c.getWssAudience(), "https://", "wss://", 1)
-	return wssBaseURI + "&id=" + c.negotiateRes.ConnectionID
-}
-
-func (c *Client) getWssAudience() string {
-	return fmt.Sprintf("%s/%s/?hub=%s", c.parsedConnStr.Endpoint.String(), c.audType, strings.ToLower(c.hubName))
+// clientToken returns a client access token identifying the client for the audience on the endpoint along with the
+// claims it was issued with
+func (c *Client) clientToken(ctx context.Context, endpoint *ServiceEndpoint, audience string) (string, *ClientClaims, error) {
+	return c.userToken(ctx, endpoint, audience, c.name)
 }
 
-func (c *Client) getBroadcastURI() string {
-	return c.getBaseURI()
-}
+// userToken returns a client access token identifying the user for the audience on the endpoint along with the claims
+// built for the user
+func (c *Client) userToken(ctx context.Context, endpoint *ServiceEndpoint, audience, userID string) (string, *ClientClaims, error) {
+	claims, err := c.buildClaims(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
 
-func (c *Client) getSendToUserURI(userID string) string {
-	return fmt.Sprintf("%s/users/%s", c.getBaseURI(), userID)
+	token, err := c.managers[endpoint].clientToken(ctx, clientTokenRequest{
+		Audience: audience,
+		UserID:   userID,
+		Claims:   claims,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token.Token, claims, nil
 }
 
-func (c *Client) getSendToGroupURI(groupName string) string {
-	return fmt.Sprintf("%s/groups/%s", c.getBaseURI(), groupName)
+// endpointForURI returns the endpoint serving the URI so requests are signed with the matching key
+func (c *Client) endpointForURI(ctx context.Context, uri string) (*ServiceEndpoint, error) {
+	for _, endpoint := range c.endpoints {
+		parsed := endpoint.parsedConnStr
+		for _, base := range []*url.URL{parsed.Endpoint, parsed.ServerEndpoint, parsed.ClientEndpoint} {
+			if strings.HasPrefix(uri, base.String()) {
+				return endpoint, nil
+			}
+		}
+	}
+	return c.negotiateEndpoint(ctx)
 }
 
-func (c *Client) getGroupUserURI(groupName, userID string) string {
-	return fmt.Sprintf("%s/groups/%s/users/%s", c.getBaseURI(), groupName, userID)
+func (c *Client) getWssURI(endpoint *ServiceEndpoint, negotiateRes *negotiateResponse) string {
+	wssBaseURI := c.getHubURI(endpoint.parsedConnStr.ClientEndpoint)
+	wssBaseURI = strings.Replace(wssBaseURI, "https://", "wss://", 1)
+	wssBaseURI = strings.Replace(wssBaseURI, "http://", "ws://", 1)
+	return wssBaseURI + "&id=" + negotiateRes.ConnectionID
 }
 
-func (c *Client) getUsersGroupsURI(userID string) string {
-	return fmt.Sprintf("%s/users/%s/groups", c.getBaseURI(), userID)
+// getWssAudience returns the audience of client access tokens, which is always issued for the service endpoint even
+// when clients connect through a separate client endpoint
+func (c *Client) getWssAudience(endpoint *ServiceEndpoint) string {
+	return c.getHubURI(endpoint.GetEndpoint())
 }
 
-func (c *Client) getBaseURI() string {
-	return fmt.Sprintf("%s/api/v1/hubs/%s", c.parsedConnStr.Endpoint, strings.ToLower(c.hubName))
+func (c *Client) getHubURI(base *url.URL) string {
+	return fmt.Sprintf("%s/%s/?hub=%s", base.String(), c.audType, strings.ToLower(c.hubName))
 }
 
 func newHTTPClient() *http.Client {
@@ -499,14 +770,35 @@ func newHTTPClient() *http.Client {
 	}
 }
 
-func (c *Client) negotiateOnce(ctx context.Context) error {
+// resetNegotiation discards the previous negotiation so the next connection negotiates again
+func (c *Client) resetNegotiation() {
+	c.nMutex.Lock()
+	defer c.nMutex.Unlock()
+	c.negotiateRes = nil
+}
+
+// negotiateOnce negotiates with the endpoint a listener should connect to, reusing the previous negotiation if the
+// endpoint has not changed
+func (c *Client) negotiateOnce(ctx context.Context) (*ServiceEndpoint, *negotiateResponse, error) {
 	c.nMutex.Lock()
 	defer c.nMutex.Unlock()
 
-	if c.negotiateRes == nil {
-		res, err := c.negotiate(ctx)
+	endpoint, err := c.negotiateEndpoint(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.negotiateRes == nil || c.endpoint != endpoint {
+		signedWith := c.managers[endpoint].accessKey()
+		res, err := c.negotiate(ctx, endpoint)
+		if c.managers[endpoint].rejectAccessKey(signedWith, err) {
+			res, err = c.negotiate(ctx, endpoint)
+		}
 		if err != nil {
-			return err
+			if isUnhealthyEndpointError(ctx, err) {
+				endpoint.setHealthy(false)
+			}
+			return nil, nil, err
 		}
 
 		found := false
@@ -517,38 +809,36 @@ func (c *Client) negotiateOnce(ctx context.Context) error {
 		}
 
 		if !found {
-			return errors.New("WebSockets transport is not supported by the service")
+			return nil, nil, errors.New("WebSockets transport is not supported by the service")
 		}
 
 		c.negotiateRes = res
+		c.endpoint = endpoint
 	}
 
-	return nil
+	return c.endpoint, c.negotiateRes, nil
 }
 
-func (c *Client) negotiate(ctx context.Context) (*negotiateResponse, error) {
-	endpoint := c.parsedConnStr.Endpoint
-	negotiateURI := fmt.Sprintf("%s/%s/%s", endpoint, c.audType, "negotiate?hub="+c.hubName)
+func (c *Client) negotiate(ctx context.Context, endpoint *ServiceEndpoint) (*negotiateResponse, error) {
+	negotiateURI := fmt.Sprintf("%s/%s/%s", endpoint.parsedConnStr.ClientEndpoint, c.audType, "negotiate?hub="+c.hubName)
 	req, err := http.NewRequest(http.MethodPost, negotiateURI, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	audience := c.getWssAudience()
-	token, err := c.generateToken(audience, 2*time.Hour)
+	audience := c.getWssAudience(endpoint)
+	token, _, err := c.clientToken(ctx, endpoint, audience)
 	if err != nil {
-		return nil, err
+		return nil, tokenProviderError{err: err}
 	}
 
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
-	req.WithContext(ctx)
 	client := newHTTPClient()
-	res, err := client.Do(req)
+	res, err := client.Do(req.WithContext(ctx))
 	defer closeRes(res)
-
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 
 	bodyBits, err := ioutil.ReadAll(res.Body)
@@ -557,7 +847,7 @@ func (c *Client) negotiate(ctx context.Context) (*negotiateResponse, error) {
 	}
 
 	if res.StatusCode > 399 {
-		return nil, &SendFailureError{
+		return nil, SendFailureError{
 			StatusCode: res.StatusCode,
 			Body:       string(bodyBits),
 		}
@@ -595,3 +885,7 @@ func closeRes(res *http.Response) {
 		_ = res.Body.Close()
 	}
 }
+
+func (re reconnectError) Error() string {
+	return re.err.Error()
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,8 +18,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
 
 	"github.com/devigned/signalr-go"
+	"github.com/devigned/signalr-go/signalrtest"
 )
 
 type (
@@ -63,6 +69,25 @@ func init() {
 	rand.Seed(time.Now().Unix())
 }
 
+// TestMain runs the tests against the SignalR service in SIGNALR_CONNECTION_STRING, or against the emulator from
+// signalrtest if none is configured
+func TestMain(m *testing.M) {
+	if os.Getenv("SIGNALR_CONNECTION_STRING") != "" {
+		os.Exit(m.Run())
+	}
+
+	emulator, err := signalrtest.NewServer()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	_ = os.Setenv("SIGNALR_CONNECTION_STRING", emulator.ConnectionString())
+	code := m.Run()
+	emulator.Close()
+	os.Exit(code)
+}
+
 func TestClientWithName(t *testing.T) {
 	name := "myClient"
 	client, err := signalr.NewClient(
@@ -147,6 +172,117 @@ func TestClient_ListenWithHandlerMethod(t *testing.T) {
 	})
 }
 
+func TestClient_ListenReconnects(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the service asks the first connection to reconnect, then batches a ping and an invocation into one message
+	var negotiations int32
+	connections := make(chan string, 2)
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/client/negotiate" {
+			n := atomic.AddInt32(&negotiations, 1)
+			_, _ = fmt.Fprintf(w, `{"connectionId":"conn%d","availableTransports":[{"transport":"WebSockets"}]}`, n)
+			return
+		}
+
+		conn, err := websocket.Accept(w, r, websocket.AcceptOptions{})
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+		}()
+
+		id := r.URL.Query().Get("id")
+		connections <- id
+		if err := readRecords(r.Context(), conn); err != nil {
+			return
+		}
+		if err := writeRecords(r.Context(), conn, "{}\x1e"); err != nil {
+			return
+		}
+		records := `{"type":7,"error":"rebalancing","allowReconnect":true}` + "\x1e"
+		if id != "conn1" {
+			records = `{"type":6}` + "\x1e" + `{"type":1,"target":"Reconnected","arguments":["` + id + `"]}` + "\x1e"
+		}
+		if err := writeRecords(r.Context(), conn, records); err != nil {
+			return
+		}
+		_ = readRecords(r.Context(), conn)
+	}))
+	defer service.Close()
+
+	client, err := signalr.NewClient("Endpoint="+service.URL+";AccessKey=foo+bar+baz=;Version=1.0;", "chat")
+	require.NoError(t, err)
+
+	listenCtx, stop := context.WithCancel(ctx)
+	var args []json.RawMessage
+	err = client.Listen(listenCtx, signalr.HandlerFunc(func(ctx context.Context, target string, arguments []json.RawMessage) error {
+		args = arguments
+		stop()
+		return nil
+	}))
+	require.NoError(t, err)
+
+	// connection IDs are single use, so reconnecting negotiates again
+	assert.Equal(t, "conn1", <-connections)
+	assert.Equal(t, "conn2", <-connections)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&negotiations))
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`"conn2"`)}, args)
+}
+
+func TestClient_ListenGivesUpReconnecting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// every connection is asked to reconnect and the negotiation after it fails
+	var negotiations int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/client/negotiate" {
+			if atomic.AddInt32(&negotiations, 1) > 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"connectionId":"conn1","availableTransports":[{"transport":"WebSockets"}]}`)
+			return
+		}
+
+		conn, err := websocket.Accept(w, r, websocket.AcceptOptions{})
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+		}()
+
+		if err := readRecords(r.Context(), conn); err != nil {
+			return
+		}
+		if err := writeRecords(r.Context(), conn, "{}\x1e"); err != nil {
+			return
+		}
+		_ = writeRecords(r.Context(), conn, `{"type":7,"error":"rebalancing","allowReconnect":true}`+"\x1e")
+	}))
+	defer service.Close()
+
+	client, err := signalr.NewClient("Endpoint="+service.URL+";AccessKey=foo+bar+baz=;Version=1.0;", "chat",
+		signalr.ClientWithMaxReconnectAttempts(2))
+	require.NoError(t, err)
+
+	err = client.Listen(ctx, signalr.HandlerFunc(func(ctx context.Context, target string, args []json.RawMessage) error {
+		return nil
+	}))
+	require.IsType(t, signalr.SendFailureError{}, err)
+	assert.Equal(t, http.StatusServiceUnavailable, err.(signalr.SendFailureError).StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&negotiations))
+	assert.NoError(t, ctx.Err())
+
+	_, err = signalr.NewClient("Endpoint="+service.URL+";AccessKey=foo+bar+baz=;Version=1.0;", "chat",
+		signalr.ClientWithMaxReconnectAttempts(-1))
+	assert.Error(t, err)
+}
+
 func TestClient_SendToUserAndReceive(t *testing.T) {
 	clientName := uuid.Must(uuid.NewRandom()).String()
 	withContext(t, func(ctx context.Context, client *signalr.Client) {
@@ -267,6 +403,39 @@ func TestClient_SendToUser(t *testing.T) {
 	})
 }
 
+func TestClientWithBatchConcurrency(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var inFlight, maxSeen, sent int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for seen := atomic.LoadInt32(&maxSeen); n > seen && !atomic.CompareAndSwapInt32(&maxSeen, seen, n); {
+			seen = atomic.LoadInt32(&maxSeen)
+		}
+		atomic.AddInt32(&sent, 1)
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer service.Close()
+
+	connStr := "Endpoint=" + service.URL + ";AccessKey=foo+bar+baz=;Version=1.0;"
+	client, err := signalr.NewClient(connStr, "hub1", signalr.ClientWithBatchConcurrency(2))
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("foo")
+	require.NoError(t, err)
+	require.NoError(t, client.SendToUsers(ctx, msg, []string{"user1", "user2", "user3", "user4", "user5"}))
+	require.NoError(t, client.SendToGroups(ctx, msg, []string{"group1", "group2", "group3"}))
+	assert.Equal(t, int32(8), atomic.LoadInt32(&sent))
+	seen := atomic.LoadInt32(&maxSeen)
+	assert.True(t, seen <= 2, "expected at most 2 concurrent requests, saw %d", seen)
+
+	_, err = signalr.NewClient(connStr, "hub1", signalr.ClientWithBatchConcurrency(0))
+	assert.Error(t, err)
+}
+
 func buildClient(t *testing.T, hubName string, opts ...signalr.ClientOption) *signalr.Client {
 	client, err := signalr.NewClient(os.Getenv("SIGNALR_CONNECTION_STRING"), hubName, opts...)
 	if err != nil {
@@ -311,3 +480,25 @@ func (mph *MessagePrinterHandler) Println(ctx context.Context, message string) e
 func (mph *MessagePrinterHandler) OnStart() {
 	mph.onStart()
 }
+
+// readRecords reads and discards the next message from the connection
+func readRecords(ctx context.Context, conn *websocket.Conn) error {
+	_, reader, err := conn.Reader(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = ioutil.ReadAll(reader)
+	return err
+}
+
+// writeRecords writes the hub protocol records to the connection as a single message
+func writeRecords(ctx context.Context, conn *websocket.Conn, records string) error {
+	writer, err := conn.Writer(ctx, websocket.MessageText)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write([]byte(records)); err != nil {
+		return err
+	}
+	return writer.Close()
+}
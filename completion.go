@@ -0,0 +1,90 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+type (
+	// completionMessage returns the result of an invocation to the caller
+	completionMessage struct {
+		Type         messageType       `json:"type"`
+		Headers      map[string]string `json:"headers,omitempty"`
+		InvocationID string            `json:"invocationId"`
+		Result       json.RawMessage   `json:"result,omitempty"`
+		Error        string            `json:"error,omitempty"`
+	}
+)
+
+const (
+	// the result kinds of MessagePack completion messages
+	msgpackErrorResult   = 1
+	msgpackVoidResult    = 2
+	msgpackNonVoidResult = 3
+)
+
+// newCompletionMessage creates the completion of an invocation from the values its handler method returned
+func newCompletionMessage(msg *InvocationMessage, result interface{}, hasResult bool, invokeErr error) (*completionMessage, error) {
+	completion := &completionMessage{
+		Type:         completionMessageType,
+		InvocationID: msg.InvocationID,
+	}
+
+	switch {
+	case invokeErr != nil:
+		completion.Error = completionError(msg.Target, invokeErr)
+	case hasResult:
+		bits, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		completion.Result = bits
+	}
+	return completion, nil
+}
+
+// completionError is the error returned to the caller of a failed invocation. Only the messages of `UpstreamError`s
+// are returned so internal details of other errors are not disclosed to clients.
+func completionError(target string, err error) string {
+	if ue, ok := err.(UpstreamError); ok {
+		return ue.Message
+	}
+	return fmt.Sprintf("An unexpected error occurred invoking '%s' on the server.", target)
+}
+
+// jsonRecord encodes the completion as a terminated JSON hub protocol record
+func (cm *completionMessage) jsonRecord() ([]byte, error) {
+	bits, err := json.Marshal(cm)
+	if err != nil {
+		return nil, err
+	}
+	return append(bits, messageTerminator), nil
+}
+
+// msgpackRecord encodes the completion as a length prefixed MessagePack hub protocol record
+func (cm *completionMessage) msgpackRecord() ([]byte, error) {
+	headers := make(map[string]interface{}, len(cm.Headers))
+	for key, value := range cm.Headers {
+		headers[key] = value
+	}
+
+	fields := []interface{}{int64(cm.Type), headers, cm.InvocationID}
+	switch {
+	case cm.Error != "":
+		fields = append(fields, int64(msgpackErrorResult), cm.Error)
+	case cm.Result != nil:
+		fields = append(fields, int64(msgpackNonVoidResult), cm.Result)
+	default:
+		fields = append(fields, int64(msgpackVoidResult))
+	}
+
+	var record bytes.Buffer
+	if err := encodeMsgpack(&record, fields); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	appendMsgpackRecord(&buf, record.Bytes())
+	return buf.Bytes(), nil
+}
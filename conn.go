@@ -1,43 +1,145 @@
 package signalr
 
 import (
-	"errors"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
 // ParsedConnString is the structure extracted from a SignalR connection string
 type ParsedConnString struct {
+	// Endpoint is the URL of the service instance, including Port if one was specified. Access tokens are always
+	// issued for this endpoint.
 	Endpoint *url.URL
 	Key      string
 	Version  string
+	Port     int
+	// ClientEndpoint is the URL clients connect to; it is the Endpoint unless a ClientEndpoint was specified
+	ClientEndpoint *url.URL
+	// ServerEndpoint is the URL REST calls are sent to; it is the Endpoint unless a ServerEndpoint was specified
+	ServerEndpoint *url.URL
+	AuthType       string
+	ClientID       string
+	ClientSecret   string
+	TenantID       string
 }
 
-// ParseConnectionString will parse the SignalR connection string from the Azure Portal
+const (
+	connStrEndpoint       = "endpoint"
+	connStrAccessKey      = "accesskey"
+	connStrVersion        = "version"
+	connStrPort           = "port"
+	connStrClientEndpoint = "clientendpoint"
+	connStrServerEndpoint = "serverendpoint"
+	connStrAuthType       = "authtype"
+	connStrClientID       = "clientid"
+	connStrClientSecret   = "clientsecret"
+	connStrTenantID       = "tenantid"
+
+	// AuthTypeAAD is the connection string AuthType for authenticating with Azure Active Directory rather than an
+	// access key. With a ClientSecret the service principal identified by TenantId and ClientId is used, otherwise the
+	// managed identity of the host, which is user-assigned if a ClientId is specified.
+	AuthTypeAAD = "aad"
+)
+
+// ParseConnectionString will parse the SignalR connection string from the Azure Portal. Keys are case-insensitive,
+// empty segments are ignored and keys this library does not use are skipped.
 func ParseConnectionString(connStr string) (*ParsedConnString, error) {
-	connStr = strings.TrimSuffix(connStr, ";")
-	splits := strings.Split(connStr, ";")
-	parsed := new(ParsedConnString)
-	for _, combo := range splits {
+	values := make(map[string]string)
+	for i, combo := range strings.Split(connStr, ";") {
+		if strings.TrimSpace(combo) == "" {
+			continue
+		}
+
 		location := strings.Index(combo, "=") // find the first instance of a "="
 		if location == -1 {
-			return nil, errors.New("connStr: " + connStr + " did not have a '=' between the ';', so it's malformed.")
-		}
-		key := combo[0:location]
-		value := combo[location+1:]
-		if key == "Endpoint" {
-			u, err := url.Parse(value)
-			if err != nil {
-				return nil, err
-			}
-			parsed.Endpoint = u
-		} else if key == "AccessKey" {
-			parsed.Key = value
-		} else if key == "Version" {
-			parsed.Version = value
-		} else {
-			return nil, errors.New("unknown key == " + key)
+			// don't echo the segment as it may contain the access key
+			return nil, ConnectionStringError{Field: "segment " + strconv.Itoa(i+1), Reason: "is missing a '=' between the key and value"}
+		}
+
+		key := strings.ToLower(strings.TrimSpace(combo[0:location]))
+		if _, ok := values[key]; ok {
+			return nil, ConnectionStringError{Field: strings.TrimSpace(combo[0:location]), Reason: "is specified more than once"}
+		}
+		values[key] = strings.TrimSpace(combo[location+1:])
+	}
+
+	parsed := &ParsedConnString{
+		Key:          values[connStrAccessKey],
+		Version:      values[connStrVersion],
+		AuthType:     strings.ToLower(values[connStrAuthType]),
+		ClientID:     values[connStrClientID],
+		ClientSecret: values[connStrClientSecret],
+		TenantID:     values[connStrTenantID],
+	}
+
+	endpoint, err := parseEndpoint("Endpoint", values[connStrEndpoint], true)
+	if err != nil {
+		return nil, err
+	}
+	parsed.Endpoint = endpoint
+
+	if port, ok := values[connStrPort]; ok {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return nil, ConnectionStringError{Field: "Port", Reason: "must be a number between 1 and 65535"}
+		}
+		parsed.Port = p
+		parsed.Endpoint.Host = net.JoinHostPort(parsed.Endpoint.Hostname(), port)
+	}
+
+	if parsed.ClientEndpoint, err = parseEndpoint("ClientEndpoint", values[connStrClientEndpoint], false); err != nil {
+		return nil, err
+	}
+	if parsed.ClientEndpoint == nil {
+		clientEndpoint := *parsed.Endpoint
+		parsed.ClientEndpoint = &clientEndpoint
+	}
+
+	if parsed.ServerEndpoint, err = parseEndpoint("ServerEndpoint", values[connStrServerEndpoint], false); err != nil {
+		return nil, err
+	}
+	if parsed.ServerEndpoint == nil {
+		serverEndpoint := *parsed.Endpoint
+		parsed.ServerEndpoint = &serverEndpoint
+	}
+
+	switch parsed.AuthType {
+	case "":
+		if parsed.Key == "" {
+			return nil, ConnectionStringError{Field: "AccessKey", Reason: "is required unless an AuthType is specified"}
+		}
+	case AuthTypeAAD:
+		if parsed.ClientSecret != "" && parsed.TenantID == "" {
+			return nil, ConnectionStringError{Field: "TenantId", Reason: "is required when a ClientSecret is specified"}
 		}
+		if parsed.ClientSecret != "" && parsed.ClientID == "" {
+			return nil, ConnectionStringError{Field: "ClientId", Reason: "is required when a ClientSecret is specified"}
+		}
+	default:
+		return nil, ConnectionStringError{Field: "AuthType", Reason: "must be " + strconv.Quote(AuthTypeAAD) + " if specified"}
 	}
+
 	return parsed, nil
 }
+
+// parseEndpoint parses an absolute http or https endpoint URL, dropping any trailing '/'
+func parseEndpoint(field, value string, required bool) (*url.URL, error) {
+	if value == "" {
+		if required {
+			return nil, ConnectionStringError{Field: field, Reason: "is required"}
+		}
+		return nil, nil
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(value, "/"))
+	if err != nil {
+		return nil, ConnectionStringError{Field: field, Reason: err.Error()}
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ConnectionStringError{Field: field, Reason: "must be an absolute http or https URL"}
+	}
+	return u, nil
+}
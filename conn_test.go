@@ -1,9 +1,15 @@
 package signalr
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseConnectionString(t *testing.T) {
@@ -13,4 +19,73 @@ func TestParseConnectionString(t *testing.T) {
 	assert.NotNil(t, parsed.Endpoint)
 	assert.Equal(t, "foo+bar+baz=", parsed.Key)
 	assert.Equal(t, "1.0", parsed.Version)
+	assert.Equal(t, parsed.Endpoint.String(), parsed.ClientEndpoint.String())
+	assert.Equal(t, parsed.Endpoint.String(), parsed.ServerEndpoint.String())
+}
+
+func TestParseConnectionString_AllKeys(t *testing.T) {
+	connStr := "endpoint=https://foo.service.signalr.net/;;PORT=8443;clientEndpoint=https://client.contoso.com;" +
+		"ServerEndpoint=https://server.contoso.com:444;AuthType=AAD;ClientId=my-client-id;Version=1.0;;"
+	parsed, err := ParseConnectionString(connStr)
+	require.NoError(t, err)
+	assert.Equal(t, "https://foo.service.signalr.net:8443", parsed.Endpoint.String())
+	assert.Equal(t, 8443, parsed.Port)
+	assert.Equal(t, "https://client.contoso.com", parsed.ClientEndpoint.String())
+	assert.Equal(t, "https://server.contoso.com:444", parsed.ServerEndpoint.String())
+	assert.Equal(t, AuthTypeAAD, parsed.AuthType)
+	assert.Equal(t, "my-client-id", parsed.ClientID)
+	assert.Equal(t, "", parsed.Key)
+}
+
+func TestParseConnectionString_Invalid(t *testing.T) {
+	cases := map[string]struct {
+		connStr string
+		field   string
+	}{
+		"missing endpoint":   {connStr: "AccessKey=foo;Version=1.0", field: "Endpoint"},
+		"relative endpoint":  {connStr: "Endpoint=foo.service.signalr.net;AccessKey=foo", field: "Endpoint"},
+		"missing access key": {connStr: "Endpoint=https://foo.service.signalr.net;Version=1.0", field: "AccessKey"},
+		"bad port":           {connStr: "Endpoint=https://foo.service.signalr.net;AccessKey=foo;Port=http", field: "Port"},
+		"port out of range":  {connStr: "Endpoint=https://foo.service.signalr.net;AccessKey=foo;Port=70000", field: "Port"},
+		"bad client":         {connStr: "Endpoint=https://foo.service.signalr.net;AccessKey=foo;ClientEndpoint=ftp://foo", field: "ClientEndpoint"},
+		"bad auth type":      {connStr: "Endpoint=https://foo.service.signalr.net;AccessKey=foo;AuthType=magic", field: "AuthType"},
+		"secret no tenant":   {connStr: "Endpoint=https://foo.service.signalr.net;AuthType=aad;ClientId=foo;ClientSecret=bar", field: "TenantId"},
+		"duplicate key":      {connStr: "Endpoint=https://foo.service.signalr.net;AccessKey=foo;accesskey=bar", field: "accesskey"},
+		"missing equals":     {connStr: "Endpoint=https://foo.service.signalr.net;AccessKeyfoo", field: "segment 2"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConnectionString(c.connStr)
+			require.Error(t, err)
+			cse, ok := err.(ConnectionStringError)
+			require.True(t, ok)
+			assert.Equal(t, c.field, cse.Field)
+			assert.NotContains(t, err.Error(), "AccessKeyfoo")
+		})
+	}
+}
+
+func TestServiceManager_HonorsServerEndpoint(t *testing.T) {
+	audiences := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _, err := new(jwt.Parser).ParseUnverified(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), &jwt.StandardClaims{})
+		require.NoError(t, err)
+		audiences <- token.Claims.(*jwt.StandardClaims).Audience
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	sm, err := NewServiceManager("Endpoint=https://foo.service.signalr.net;Port=8443;ServerEndpoint="+srv.URL+";AccessKey=foo", "hub1")
+	require.NoError(t, err)
+	require.NoError(t, sm.AddUserToGroup(context.Background(), "group1", "user1"))
+	assert.Equal(t, "https://foo.service.signalr.net:8443/api/hubs/hub1/groups/group1/users/user1", <-audiences)
+}
+
+func TestClient_HonorsClientEndpoint(t *testing.T) {
+	client, err := NewClient("Endpoint=https://foo.service.signalr.net;ClientEndpoint=https://client.contoso.com:8443;AccessKey=foo", "Hub1")
+	require.NoError(t, err)
+	endpoint := client.endpoints[0]
+	assert.Equal(t, "wss://client.contoso.com:8443/client/?hub=hub1&id=abc", client.getWssURI(endpoint, &negotiateResponse{ConnectionID: "abc"}))
+	assert.Equal(t, "https://foo.service.signalr.net/client/?hub=hub1", client.getWssAudience(endpoint))
 }
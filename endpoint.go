@@ -0,0 +1,242 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// EndpointType designates the role a `ServiceEndpoint` plays when routing between several SignalR service instances
+	EndpointType int
+
+	// ServiceEndpoint is a single Azure SignalR service instance a client can send to and listen on. Endpoints track
+	// their health so traffic can fail over from unhealthy primaries to secondaries.
+	ServiceEndpoint struct {
+		name          string
+		endpointType  EndpointType
+		parsedConnStr *ParsedConnString
+		unhealthy     int32
+	}
+)
+
+const (
+	// EndpointTypePrimary endpoints receive traffic while they are healthy
+	EndpointTypePrimary EndpointType = iota
+	// EndpointTypeSecondary endpoints receive traffic when no primary endpoint is healthy or a primary fails
+	EndpointTypeSecondary
+)
+
+// NewServiceEndpoint constructs a named primary or secondary endpoint from a SignalR connection string
+func NewServiceEndpoint(name string, endpointType EndpointType, connStr string) (*ServiceEndpoint, error) {
+	parsed, err := ParseConnectionString(connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpointType != EndpointTypePrimary && endpointType != EndpointTypeSecondary {
+		return nil, errors.New("endpoint type must be either EndpointTypePrimary or EndpointTypeSecondary")
+	}
+
+	if name == "" {
+		name = parsed.Endpoint.Host
+	}
+
+	return &ServiceEndpoint{
+		name:          name,
+		endpointType:  endpointType,
+		parsedConnStr: parsed,
+	}, nil
+}
+
+// String returns the name of the endpoint type
+func (et EndpointType) String() string {
+	switch et {
+	case EndpointTypePrimary:
+		return "primary"
+	case EndpointTypeSecondary:
+		return "secondary"
+	default:
+		return "unknown"
+	}
+}
+
+// GetName returns the name of the endpoint
+func (se *ServiceEndpoint) GetName() string {
+	return se.name
+}
+
+// GetType returns whether the endpoint is a primary or a secondary
+func (se *ServiceEndpoint) GetType() EndpointType {
+	return se.endpointType
+}
+
+// GetEndpoint returns the URL of the SignalR service instance
+func (se *ServiceEndpoint) GetEndpoint() *url.URL {
+	return se.parsedConnStr.Endpoint
+}
+
+// IsHealthy returns false if the last call or health probe against the endpoint failed
+func (se *ServiceEndpoint) IsHealthy() bool {
+	return atomic.LoadInt32(&se.unhealthy) == 0
+}
+
+func (se *ServiceEndpoint) setHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&se.unhealthy, 0)
+		return
+	}
+	atomic.StoreInt32(&se.unhealthy, 1)
+}
+
+// MonitorHealth probes the health API of each of the client's endpoints every interval until the context is done.
+// Endpoints which fail a probe are marked unhealthy and receive no traffic while a healthy alternative exists; endpoints
+// marked unhealthy by a failed call are restored once a probe succeeds.
+func (c *Client) MonitorHealth(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.probeEndpoints(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) probeEndpoints(ctx context.Context) {
+	for _, endpoint := range c.endpoints {
+		err := c.managers[endpoint].CheckHealth(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		endpoint.setHealthy(err == nil)
+	}
+}
+
+// negotiateEndpoint returns the endpoint a listener should connect to
+func (c *Client) negotiateEndpoint(ctx context.Context) (*ServiceEndpoint, error) {
+	endpoint := c.router.GetNegotiateEndpoint(ctx, c.endpoints)
+	if err := c.checkRouted(endpoint); err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// checkRouted returns an error if any of the endpoints chosen by the router is not one of the client's endpoints
+func (c *Client) checkRouted(endpoints ...*ServiceEndpoint) error {
+	for _, endpoint := range endpoints {
+		if _, ok := c.managers[endpoint]; ok {
+			continue
+		}
+		if endpoint == nil {
+			return errors.New("the endpoint router returned a nil endpoint")
+		}
+		return fmt.Errorf("the endpoint router returned endpoint %q which is not one of the client's endpoints", endpoint.GetName())
+	}
+	return nil
+}
+
+// route runs the operation against each of the target endpoints chosen by the router. If an endpoint fails with an
+// error indicating it is unhealthy, it is marked as such and the operation fails over to the healthy secondary
+// endpoints which were not targeted. An error is returned if the operation was rejected by an endpoint or if it did
+// not succeed on any endpoint.
+func (c *Client) route(ctx context.Context, targets []*ServiceEndpoint, op func(ctx context.Context, sm *ServiceManager) error) error {
+	if len(targets) == 0 {
+		return errors.New("the endpoint router did not return any endpoints for the operation")
+	}
+	if err := c.checkRouted(targets...); err != nil {
+		return err
+	}
+
+	failures := make(map[string]error)
+	attempted, succeeded, failover := 0, false, false
+	var rejected error
+
+	attempt := func(endpoint *ServiceEndpoint) {
+		attempted++
+		err := op(ctx, c.managers[endpoint])
+		switch {
+		case err == nil:
+			endpoint.setHealthy(true)
+			succeeded = true
+		case isUnhealthyEndpointError(ctx, err):
+			endpoint.setHealthy(false)
+			failures[endpoint.GetName()] = err
+			failover = true
+		default:
+			failures[endpoint.GetName()] = err
+			rejected = err
+		}
+	}
+
+	for _, endpoint := range targets {
+		attempt(endpoint)
+	}
+
+	if failover {
+		for _, endpoint := range filterEndpoints(c.endpoints, EndpointTypeSecondary) {
+			if !containsEndpoint(targets, endpoint) {
+				attempt(endpoint)
+			}
+		}
+	}
+
+	if rejected == nil && succeeded {
+		return nil
+	}
+
+	if attempted == 1 {
+		for _, err := range failures {
+			return err
+		}
+	}
+
+	return BatchSendError{
+		Attempted: attempted,
+		Failures:  failures,
+	}
+}
+
+func containsEndpoint(endpoints []*ServiceEndpoint, endpoint *ServiceEndpoint) bool {
+	for _, candidate := range endpoints {
+		if candidate == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnhealthyEndpointError returns true if the error indicates the service instance is unavailable, either because it
+// could not be reached or because it responded with a 5xx status code, rather than the request being rejected or a
+// token for it not being acquired
+func isUnhealthyEndpointError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	for err != nil {
+		switch e := err.(type) {
+		case tokenProviderError:
+			return false
+		case SendFailureError:
+			return e.StatusCode >= 500
+		case net.Error:
+			return true
+		}
+
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
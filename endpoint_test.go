@@ -0,0 +1,279 @@
+package signalr_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+)
+
+type (
+	fakeService struct {
+		*httptest.Server
+		status int32
+		calls  int32
+	}
+)
+
+func newFakeService(status int) *fakeService {
+	fs := &fakeService{status: int32(status)}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fs.calls, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&fs.status)))
+	}))
+	return fs
+}
+
+func (fs *fakeService) connStr() string {
+	return "Endpoint=" + fs.URL + ";AccessKey=foo+bar+baz=;Version=1.0;"
+}
+
+func (fs *fakeService) setStatus(status int) {
+	atomic.StoreInt32(&fs.status, int32(status))
+}
+
+func (fs *fakeService) callCount() int {
+	return int(atomic.LoadInt32(&fs.calls))
+}
+
+func TestClient_EndpointFailover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	primary := newFakeService(http.StatusServiceUnavailable)
+	defer primary.Close()
+	secondary := newFakeService(http.StatusAccepted)
+	defer secondary.Close()
+
+	primaryEndpoint, err := signalr.NewServiceEndpoint("primary", signalr.EndpointTypePrimary, primary.connStr())
+	require.NoError(t, err)
+	secondaryEndpoint, err := signalr.NewServiceEndpoint("secondary", signalr.EndpointTypeSecondary, secondary.connStr())
+	require.NoError(t, err)
+
+	client, err := signalr.NewClientWithEndpoints("hub1", []*signalr.ServiceEndpoint{primaryEndpoint, secondaryEndpoint})
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("foo")
+	require.NoError(t, err)
+
+	// the primary fails, is marked unhealthy and the send fails over to the secondary
+	require.NoError(t, client.BroadcastAll(ctx, msg))
+	assert.False(t, primaryEndpoint.IsHealthy())
+	assert.Equal(t, 1, primary.callCount())
+	assert.Equal(t, 1, secondary.callCount())
+
+	// while the primary is unhealthy only the secondary receives traffic
+	require.NoError(t, client.SendToUser(ctx, msg, "user1"))
+	assert.Equal(t, 1, primary.callCount())
+	assert.Equal(t, 2, secondary.callCount())
+
+	// a successful health probe restores the primary
+	primary.setStatus(http.StatusOK)
+	monitorCtx, monitorCancel := context.WithCancel(ctx)
+	go func() {
+		_ = client.MonitorHealth(monitorCtx, 10*time.Millisecond)
+	}()
+	for !primaryEndpoint.IsHealthy() {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "primary endpoint was never restored by the health probe")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	monitorCancel()
+
+	primary.setStatus(http.StatusAccepted)
+	secondaryCalls := secondary.callCount()
+	require.NoError(t, client.AddUserToGroup(ctx, "group1", "user1"))
+	assert.Equal(t, secondaryCalls, secondary.callCount())
+}
+
+func TestClient_EndpointRejectionDoesNotFailover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	primary := newFakeService(http.StatusBadRequest)
+	defer primary.Close()
+	secondary := newFakeService(http.StatusAccepted)
+	defer secondary.Close()
+
+	primaryEndpoint, err := signalr.NewServiceEndpoint("primary", signalr.EndpointTypePrimary, primary.connStr())
+	require.NoError(t, err)
+	secondaryEndpoint, err := signalr.NewServiceEndpoint("secondary", signalr.EndpointTypeSecondary, secondary.connStr())
+	require.NoError(t, err)
+
+	client, err := signalr.NewClientWithEndpoints("hub1", []*signalr.ServiceEndpoint{primaryEndpoint, secondaryEndpoint})
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("foo")
+	require.NoError(t, err)
+
+	err = client.BroadcastAll(ctx, msg)
+	require.Error(t, err)
+	sfe, ok := err.(signalr.SendFailureError)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, sfe.StatusCode)
+	assert.True(t, primaryEndpoint.IsHealthy())
+	assert.Equal(t, 0, secondary.callCount())
+}
+
+func TestClient_EndpointHealthIgnoresTokenFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// neither the identity endpoint nor the second service instance can be reached
+	aad := httptest.NewServer(http.NotFoundHandler())
+	aad.Close()
+	service := newFakeService(http.StatusAccepted)
+	defer service.Close()
+	unreachable := newFakeService(http.StatusAccepted)
+	unreachable.Close()
+
+	provider, err := signalr.NewClientSecretTokenProvider("tenant1", "client1", "secret", signalr.AADWithAuthorityHost(aad.URL))
+	require.NoError(t, err)
+	endpoint, err := signalr.NewServiceEndpoint("primary", signalr.EndpointTypePrimary, service.connStr())
+	require.NoError(t, err)
+	client, err := signalr.NewClientWithEndpoints("hub1", []*signalr.ServiceEndpoint{endpoint}, signalr.ClientWithTokenProvider(provider))
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("foo")
+	require.NoError(t, err)
+
+	// failing to acquire a token says nothing about the service instance
+	assert.Error(t, client.BroadcastAll(ctx, msg))
+	assert.True(t, endpoint.IsHealthy())
+	assert.Equal(t, 0, service.callCount())
+
+	// failing to reach the service instance marks it unhealthy
+	endpoint, err = signalr.NewServiceEndpoint("primary", signalr.EndpointTypePrimary, unreachable.connStr())
+	require.NoError(t, err)
+	client, err = signalr.NewClientWithEndpoints("hub1", []*signalr.ServiceEndpoint{endpoint})
+	require.NoError(t, err)
+	assert.Error(t, client.BroadcastAll(ctx, msg))
+	assert.False(t, endpoint.IsHealthy())
+}
+
+func TestNewClientWithEndpoints_DuplicateNames(t *testing.T) {
+	first, err := signalr.NewServiceEndpoint("east", signalr.EndpointTypePrimary, "Endpoint=https://east.service.signalr.net;AccessKey=foo;Version=1.0;")
+	require.NoError(t, err)
+	second, err := signalr.NewServiceEndpoint("east", signalr.EndpointTypeSecondary, "Endpoint=https://west.service.signalr.net;AccessKey=foo;Version=1.0;")
+	require.NoError(t, err)
+
+	_, err = signalr.NewClientWithEndpoints("hub1", []*signalr.ServiceEndpoint{first, second})
+	assert.Error(t, err)
+}
+
+type (
+	shardingRouter struct {
+		signalr.DefaultEndpointRouter
+	}
+)
+
+// GetEndpointsForUser sends each user to a single endpoint chosen by the first byte of the user ID
+func (shardingRouter) GetEndpointsForUser(_ context.Context, userID string, endpoints []*signalr.ServiceEndpoint) []*signalr.ServiceEndpoint {
+	return endpoints[int(userID[0])%len(endpoints) : int(userID[0])%len(endpoints)+1]
+}
+
+// GetEndpointsForBroadcast sends broadcasts to every endpoint
+func (shardingRouter) GetEndpointsForBroadcast(_ context.Context, endpoints []*signalr.ServiceEndpoint) []*signalr.ServiceEndpoint {
+	return endpoints
+}
+
+func TestClient_EndpointRouter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	services := []*fakeService{newFakeService(http.StatusAccepted), newFakeService(http.StatusAccepted)}
+	var endpoints []*signalr.ServiceEndpoint
+	for i, service := range services {
+		defer service.Close()
+		endpoint, err := signalr.NewServiceEndpoint(fmt.Sprintf("shard%d", i), signalr.EndpointTypePrimary, service.connStr())
+		require.NoError(t, err)
+		endpoints = append(endpoints, endpoint)
+	}
+
+	client, err := signalr.NewClientWithEndpoints("hub1", endpoints, signalr.ClientWithEndpointRouter(shardingRouter{}))
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("foo")
+	require.NoError(t, err)
+
+	require.NoError(t, client.SendToUser(ctx, msg, "a")) // 'a' is 97, so shard 1
+	assert.Equal(t, 0, services[0].callCount())
+	assert.Equal(t, 1, services[1].callCount())
+
+	require.NoError(t, client.SendToUser(ctx, msg, "b")) // 'b' is 98, so shard 0
+	assert.Equal(t, 1, services[0].callCount())
+	assert.Equal(t, 1, services[1].callCount())
+
+	require.NoError(t, client.BroadcastAll(ctx, msg))
+	assert.Equal(t, 2, services[0].callCount())
+	assert.Equal(t, 2, services[1].callCount())
+}
+
+type (
+	foreignRouter struct {
+		signalr.DefaultEndpointRouter
+		endpoint *signalr.ServiceEndpoint
+	}
+)
+
+// GetNegotiateEndpoint returns an endpoint the client was not configured with
+func (fr foreignRouter) GetNegotiateEndpoint(_ context.Context, _ []*signalr.ServiceEndpoint) *signalr.ServiceEndpoint {
+	return fr.endpoint
+}
+
+// GetEndpointsForBroadcast returns an endpoint the client was not configured with
+func (fr foreignRouter) GetEndpointsForBroadcast(_ context.Context, _ []*signalr.ServiceEndpoint) []*signalr.ServiceEndpoint {
+	return []*signalr.ServiceEndpoint{fr.endpoint}
+}
+
+func TestClient_EndpointRouterUnknownEndpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	service := newFakeService(http.StatusAccepted)
+	defer service.Close()
+	endpoint, err := signalr.NewServiceEndpoint("primary", signalr.EndpointTypePrimary, service.connStr())
+	require.NoError(t, err)
+	foreign, err := signalr.NewServiceEndpoint("foreign", signalr.EndpointTypePrimary, service.connStr())
+	require.NoError(t, err)
+
+	client, err := signalr.NewClientWithEndpoints("hub1", []*signalr.ServiceEndpoint{endpoint},
+		signalr.ClientWithEndpointRouter(foreignRouter{endpoint: foreign}))
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("foo")
+	require.NoError(t, err)
+	assert.Error(t, client.BroadcastAll(ctx, msg))
+	assert.Error(t, client.Listen(ctx, signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+		return nil
+	})))
+	_, err = client.GenerateClientAccess(ctx, "user1")
+	assert.Error(t, err)
+	assert.Equal(t, 0, service.callCount())
+
+	// a router returning no endpoint fails the same way rather than falling back to the first endpoint
+	client, err = signalr.NewClientWithEndpoints("hub1", []*signalr.ServiceEndpoint{endpoint},
+		signalr.ClientWithEndpointRouter(foreignRouter{}))
+	require.NoError(t, err)
+	assert.Error(t, client.BroadcastAll(ctx, msg))
+	assert.EqualError(t, client.Listen(ctx, signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+		return nil
+	})), "the endpoint router returned a nil endpoint")
+	_, err = client.GenerateClientAccess(ctx, "user1")
+	assert.EqualError(t, err, "the endpoint router returned a nil endpoint")
+	assert.Equal(t, 0, service.callCount())
+
+	_, err = signalr.NewClientWithEndpoints("hub1", []*signalr.ServiceEndpoint{endpoint}, signalr.ClientWithEndpointRouter(nil))
+	assert.Error(t, err)
+}
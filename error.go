@@ -2,6 +2,8 @@ package signalr
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type (
@@ -10,8 +12,112 @@ type (
 		StatusCode int
 		Body       string
 	}
+
+	// BatchSendError reports the targets of a batch send which failed along with the error for each target
+	BatchSendError struct {
+		Attempted int
+		Failures  map[string]error
+	}
+
+	// TokenRequestError provides added error information when acquiring a token from an identity endpoint fails
+	TokenRequestError struct {
+		Message    string
+		StatusCode int
+		Body       string
+	}
+
+	// UpstreamError is returned by upstream handlers to respond to the service with a specific status code, such as
+	// rejecting a connection with 403 Forbidden
+	UpstreamError struct {
+		StatusCode int
+		Message    string
+	}
+
+	// ConnectionStringError describes the field of a SignalR connection string which is missing or invalid
+	ConnectionStringError struct {
+		Field  string
+		Reason string
+	}
+
+	// HandlerRegistrationError describes why a function could not be registered to handle a target
+	HandlerRegistrationError struct {
+		Target string
+		Reason string
+	}
+
+	// UnknownTargetError is returned when an invocation names a target no function is registered for
+	UnknownTargetError struct {
+		Target string
+	}
+
+	// HandlerPanicError is returned when the handler of an invocation panics. Value is the value the handler panicked
+	// with and Stack the stack trace of the panicking goroutine.
+	HandlerPanicError struct {
+		Target string
+		Value  interface{}
+		Stack  []byte
+	}
+
+	// InvocationArgumentsError describes why the arguments of an invocation do not suit the function handling it
+	InvocationArgumentsError struct {
+		Target string
+		Reason string
+	}
+
+	// InvocationResultError describes why the values returned by the method handling an invocation cannot be sent back
+	// as its result
+	InvocationResultError struct {
+		Target string
+		Reason string
+	}
 )
 
 func (sfe SendFailureError) Error() string {
 	return fmt.Sprintf("failed to send message with status code %d and body: %q\n", sfe.StatusCode, sfe.Body)
 }
+
+func (bse BatchSendError) Error() string {
+	targets := make([]string, 0, len(bse.Failures))
+	for target := range bse.Failures {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	details := make([]string, len(targets))
+	for i, target := range targets {
+		details[i] = fmt.Sprintf("%q: %v", target, bse.Failures[target])
+	}
+	return fmt.Sprintf("failed to send to %d of %d targets: %s", len(bse.Failures), bse.Attempted, strings.Join(details, "; "))
+}
+
+func (cse ConnectionStringError) Error() string {
+	return fmt.Sprintf("invalid connection string: %s %s", cse.Field, cse.Reason)
+}
+
+func (ue UpstreamError) Error() string {
+	return fmt.Sprintf("upstream request failed with status code %d: %s", ue.StatusCode, ue.Message)
+}
+
+func (tre TokenRequestError) Error() string {
+	return fmt.Sprintf("%s with status code %d and body: %q", tre.Message, tre.StatusCode, tre.Body)
+}
+
+func (hre HandlerRegistrationError) Error() string {
+	return fmt.Sprintf("cannot register handler for target %q: %s", hre.Target, hre.Reason)
+}
+
+func (ute UnknownTargetError) Error() string {
+	return fmt.Sprintf("no handler is registered for target %q", ute.Target)
+}
+
+func (iae InvocationArgumentsError) Error() string {
+	return fmt.Sprintf("invalid arguments for target %q: %s", iae.Target, iae.Reason)
+}
+
+func (ire InvocationResultError) Error() string {
+	return fmt.Sprintf("invalid result from target %q: %s", ire.Target, ire.Reason)
+}
+
+func (hpe HandlerPanicError) Error() string {
+	return fmt.Sprintf("handler for target %q panicked: %v", hpe.Target, hpe.Value)
+}
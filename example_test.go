@@ -3,6 +3,7 @@ package signalr_test
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"os"
 
 	"github.com/devigned/signalr-go"
@@ -59,7 +60,7 @@ func ExampleClient_Listen() {
 	// since `MessagePrinterHandler` implements `NotifiedHandler` it will receive a call to `OnStart` when listening
 
 	go func() {
-		err = client.Listen(listenCtx, mph)
+		err := client.Listen(listenCtx, mph)
 		if err != nil {
 			fmt.Println(err)
 			started <- struct{}{}
@@ -80,7 +81,8 @@ func ExampleClient_SendToUser() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx := context.Background()
+	listenCtx, cancel := context.WithCancel(ctx)
 
 	started := make(chan struct{}, 1)
 	mph := &MessagePrinterHandler{
@@ -91,7 +93,7 @@ func ExampleClient_SendToUser() {
 	}
 
 	go func() {
-		err = client.Listen(ctx, mph)
+		err := client.Listen(listenCtx, mph)
 		if err != nil {
 			fmt.Println(err)
 			started <- struct{}{}
@@ -112,7 +114,7 @@ func ExampleClient_SendToUser() {
 	}
 
 	// wait for the `MessagePrinterHandler` to call cancel on the context
-	<-ctx.Done()
+	<-listenCtx.Done()
 
 	// Output: hello only to you
 }
@@ -126,7 +128,8 @@ func ExampleClient_BroadcastAll() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx := context.Background()
+	listenCtx, cancel := context.WithCancel(ctx)
 
 	started := make(chan struct{}, 1)
 	mph := &MessagePrinterHandler{
@@ -137,7 +140,7 @@ func ExampleClient_BroadcastAll() {
 	}
 
 	go func() {
-		err = client.Listen(ctx, mph)
+		err := client.Listen(listenCtx, mph)
 		if err != nil {
 			fmt.Println(err)
 			started <- struct{}{}
@@ -158,7 +161,7 @@ func ExampleClient_BroadcastAll() {
 	}
 
 	// wait for the `MessagePrinterHandler` to call cancel on the context
-	<-ctx.Done()
+	<-listenCtx.Done()
 
 	// Output: hello world!
 }
@@ -173,7 +176,8 @@ func ExampleClient_BroadcastGroup() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx := context.Background()
+	listenCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	if err := client.AddUserToGroup(ctx, groupName, client.GetName()); err != nil {
 		fmt.Println(err)
@@ -189,7 +193,7 @@ func ExampleClient_BroadcastGroup() {
 	}
 
 	go func() {
-		err = client.Listen(ctx, mph)
+		err := client.Listen(listenCtx, mph)
 		if err != nil {
 			fmt.Println(err)
 			started <- struct{}{}
@@ -210,7 +214,7 @@ func ExampleClient_BroadcastGroup() {
 	}
 
 	// wait for the `MessagePrinterHandler` to call cancel on the context
-	<-ctx.Done()
+	<-listenCtx.Done()
 
 	// Output: I'm part of the cool group
 }
@@ -261,3 +265,50 @@ func ExampleClient_RemoveUserFromAllGroups() {
 
 	// Output: removed user "client42" from all groups
 }
+
+// userShardingRouter sends all traffic for a user to a single endpoint chosen by hashing the user ID, and sends
+// broadcasts to every endpoint
+type userShardingRouter struct {
+	signalr.DefaultEndpointRouter
+}
+
+func (userShardingRouter) GetEndpointsForUser(_ context.Context, userID string, endpoints []*signalr.ServiceEndpoint) []*signalr.ServiceEndpoint {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userID))
+	idx := int(h.Sum32() % uint32(len(endpoints)))
+	return endpoints[idx : idx+1]
+}
+
+func (userShardingRouter) GetEndpointsForBroadcast(_ context.Context, endpoints []*signalr.ServiceEndpoint) []*signalr.ServiceEndpoint {
+	return endpoints
+}
+
+func ExampleClientWithEndpointRouter() {
+	east, err := signalr.NewServiceEndpoint("east", signalr.EndpointTypePrimary, os.Getenv("SIGNALR_CONNECTION_STRING_EAST"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	west, err := signalr.NewServiceEndpoint("west", signalr.EndpointTypePrimary, os.Getenv("SIGNALR_CONNECTION_STRING_WEST"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	client, err := signalr.NewClientWithEndpoints("chat", []*signalr.ServiceEndpoint{east, west}, signalr.ClientWithEndpointRouter(userShardingRouter{}))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	msg, err := signalr.NewInvocationMessage("Println", "hello user1")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err := client.SendToUser(context.Background(), msg, "user1"); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/devigned/signalr-go
 
-go 1.12
+go 1.18
 
 require (
 	github.com/alexsasharegan/dotenv v0.0.0-20171113213728-090a4d1b5d42
@@ -9,3 +9,16 @@ require (
 	github.com/stretchr/testify v1.3.0
 	nhooyr.io/websocket v0.2.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+	go.coder.com/go-tools v0.0.0-20190317003359-0c6a35b74a16 // indirect
+	golang.org/x/lint v0.0.0-20190313153728-d0100b6bd8b3 // indirect
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a // indirect
+	golang.org/x/text v0.3.0 // indirect
+	golang.org/x/tools v0.0.0-20190419195823-c39e7748f6eb // indirect
+	golang.org/x/xerrors v0.0.0-20190315151331-d61658bd2e18 // indirect
+	mvdan.cc/sh v2.6.4+incompatible // indirect
+)
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 )
 
 type (
@@ -26,6 +28,35 @@ type (
 		Handler
 		onStart func()
 	}
+
+	// handlerDescriptor is the method table of a handler type, built once so invocations are dispatched without
+	// looking methods up by name
+	handlerDescriptor struct {
+		methods map[string]*methodDescriptor
+	}
+
+	// methodDescriptor is a method which invocations may be dispatched to, with a decoder for each of its arguments
+	methodDescriptor struct {
+		fn   reflect.Value
+		args []argumentDecoder
+	}
+
+	argumentDecoder func(raw json.RawMessage) (reflect.Value, error)
+)
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+	// reservedMethods are the methods of the handler interfaces, which are called by the library rather than by clients
+	reservedMethods = map[string]bool{
+		"Default":        true,
+		"OnStart":        true,
+		"OnConnected":    true,
+		"OnDisconnected": true,
+	}
+
+	// handlerDescriptors caches a *handlerDescriptor for each handler type
+	handlerDescriptors sync.Map
 )
 
 // Default redirects this call to the func that was provided
@@ -47,45 +78,102 @@ func (nh defaultNotifiedHandler) OnStart() {
 	nh.onStart()
 }
 
-func dispatch(ctx context.Context, handler Handler, msg *InvocationMessage) error {
-	t := reflect.TypeOf(handler)
-	if method, ok := t.MethodByName(msg.Target); ok {
+// invoke calls the method of the handler named by the target of the invocation, or `Default` if there is no such
+// method, and returns the result of the method if it returns one. Arguments the method cannot decode are returned as
+// an `InvocationArgumentsError`.
+func invoke(ctx context.Context, handler Handler, msg *InvocationMessage) (interface{}, bool, error) {
+	if inv, ok := handler.(invoker); ok {
+		return inv.invoke(ctx, msg)
+	}
+
+	receiver := reflect.ValueOf(handler)
+	method, ok := describeHandler(receiver.Type()).methods[msg.Target]
+	if !ok || len(method.args) != len(msg.Arguments) {
+		return nil, false, handler.Default(ctx, msg.Target, msg.Arguments)
+	}
+
+	in := make([]reflect.Value, len(msg.Arguments)+2) // account for instance + context + arguments
+	in[0] = receiver
+	in[1] = reflect.ValueOf(ctx)
+	for i, decode := range method.args {
+		arg, err := decode(msg.Arguments[i])
+		if err != nil {
+			return nil, false, InvocationArgumentsError{Target: msg.Target, Reason: fmt.Sprintf("argument %d: %v", i, err)}
+		}
+		in[i+2] = arg
+	}
+	return methodResult(msg.Target, method.fn.Call(in))
+}
+
+// describeHandler returns the descriptor of the handler type, building it on first use
+func describeHandler(t reflect.Type) *handlerDescriptor {
+	if desc, ok := handlerDescriptors.Load(t); ok {
+		return desc.(*handlerDescriptor)
+	}
+
+	desc := &handlerDescriptor{methods: make(map[string]*methodDescriptor)}
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
 		mt := method.Type
-		numIn := mt.NumIn()
-		if numIn == len(msg.Arguments)+2 && method.Name != "Default" { // account for instance + context + arguments
-			args := make([]reflect.Value, mt.NumIn()-1)
-			args[0] = reflect.ValueOf(ctx)
-			for i := 0; i < len(msg.Arguments); i++ {
-				argType := mt.In(i + 2)
-				newArg := reflect.New(argType)
-				newArgPtr := newArg.Interface()
-				err := json.Unmarshal(msg.Arguments[i], &newArgPtr)
-				if err != nil {
-					fmt.Println(err)
-					return handler.Default(ctx, msg.Target, msg.Arguments)
-				}
-
-				args[i+1] = newArg.Elem()
-			}
-
-			actualTarget := reflect.ValueOf(handler).MethodByName(msg.Target)
-			returns := actualTarget.Call(args)
-			if len(returns) == 0 {
-				return nil
-			}
-
-			if returns[0].IsNil() {
-				return nil
-			}
-
-			if val, ok := returns[0].Elem().Interface().(error); ok {
-				return val
-			}
-
-			fmt.Printf("ran into a return we didn't know how to deal with: %+v", returns)
-			return nil
+		// methods are dispatched to if they take a context followed by their arguments
+		if reservedMethods[method.Name] || mt.IsVariadic() || mt.NumIn() < 2 || !contextType.AssignableTo(mt.In(1)) {
+			continue
+		}
+
+		args := make([]argumentDecoder, mt.NumIn()-2)
+		for j := range args {
+			args[j] = newArgumentDecoder(mt.In(j + 2))
+		}
+		desc.methods[method.Name] = &methodDescriptor{fn: method.Func, args: args}
+	}
+
+	actual, _ := handlerDescriptors.LoadOrStore(t, desc)
+	return actual.(*handlerDescriptor)
+}
+
+// newArgumentDecoder returns a decoder of JSON arguments into values of the type
+func newArgumentDecoder(t reflect.Type) argumentDecoder {
+	return func(raw json.RawMessage) (reflect.Value, error) {
+		arg := reflect.New(t)
+		if err := json.Unmarshal(raw, arg.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return arg.Elem(), nil
+	}
+}
+
+// methodResult interprets the values returned by a handler method, which may return nothing, an error, a result, or a
+// result and an error
+func methodResult(target string, returns []reflect.Value) (interface{}, bool, error) {
+	switch {
+	case len(returns) == 0:
+		return nil, false, nil
+	case len(returns) == 1 && returns[0].Type().Implements(errorType):
+		return nil, false, asError(returns[0])
+	case len(returns) == 1:
+		return returns[0].Interface(), true, nil
+	case len(returns) == 2 && returns[1].Type().Implements(errorType):
+		if err := asError(returns[1]); err != nil {
+			return nil, false, err
 		}
+		return returns[0].Interface(), true, nil
+	}
+
+	types := make([]string, len(returns))
+	for i, value := range returns {
+		types[i] = value.Type().String()
+	}
+	return nil, false, InvocationResultError{
+		Target: target,
+		Reason: fmt.Sprintf("expected nothing, an error, a result, or a result and an error, but got (%s)", strings.Join(types, ", ")),
+	}
+}
+
+func asError(value reflect.Value) error {
+	if (value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr) && value.IsNil() {
+		return nil
 	}
 
-	return handler.Default(ctx, msg.Target, msg.Arguments)
+	err, _ := value.Interface().(error)
+	return err
 }
@@ -0,0 +1,159 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	benchObject struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	benchHandler struct {
+		defaults int
+	}
+)
+
+func (bh *benchHandler) Default(ctx context.Context, target string, args []json.RawMessage) error {
+	bh.defaults++
+	return nil
+}
+
+func (bh *benchHandler) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (bh *benchHandler) Update(ctx context.Context, id string, obj *benchObject) error {
+	return nil
+}
+
+func (bh *benchHandler) Add(ctx context.Context, a, b int) (int, error) {
+	return a + b, nil
+}
+
+func (bh *benchHandler) Crash(ctx context.Context, obj *benchObject) (string, error) {
+	return obj.Name, nil
+}
+
+func (bh *benchHandler) Pair(ctx context.Context) (int, int) {
+	return 1, 2
+}
+
+func (bh *benchHandler) OnConnected(ctx context.Context, req *UpstreamRequest) (*ConnectResponse, error) {
+	panic("lifecycle hooks must not be invoked by clients")
+}
+
+func (bh *benchHandler) OnDisconnected(ctx context.Context, req *UpstreamRequest, reason string) error {
+	panic("lifecycle hooks must not be invoked by clients")
+}
+
+func TestInvoke(t *testing.T) {
+	handler := new(benchHandler)
+	ctx := context.Background()
+
+	result, hasResult, err := invoke(ctx, handler, &InvocationMessage{Target: "Add", Arguments: benchArgs(t, 1, 2)})
+	require.NoError(t, err)
+	assert.True(t, hasResult)
+	assert.Equal(t, 3, result)
+
+	_, hasResult, err = invoke(ctx, handler, &InvocationMessage{Target: "Update", Arguments: benchArgs(t, "1", benchObject{Name: "one"})})
+	require.NoError(t, err)
+	assert.False(t, hasResult)
+
+	// unknown targets, mismatched arguments and the methods of the handler interfaces fall back to Default
+	for _, msg := range []*InvocationMessage{
+		{Target: "Missing"},
+		{Target: "Add", Arguments: benchArgs(t, 1)},
+		{Target: "Default", Arguments: benchArgs(t, "Ping", nil)},
+		{Target: "OnConnected", Arguments: benchArgs(t, UpstreamRequest{})},
+		{Target: "OnDisconnected", Arguments: benchArgs(t, UpstreamRequest{}, "forged")},
+	} {
+		_, _, err := invoke(ctx, handler, msg)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 5, handler.defaults)
+
+	// undecodable arguments are reported rather than passed to Default
+	_, hasResult, err = invoke(ctx, handler, &InvocationMessage{Target: "Add", Arguments: benchArgs(t, 1, "two")})
+	assert.False(t, hasResult)
+	require.IsType(t, InvocationArgumentsError{}, err)
+	assert.Equal(t, "Add", err.(InvocationArgumentsError).Target)
+	assert.Contains(t, err.(InvocationArgumentsError).Reason, "argument 1")
+	assert.Equal(t, 5, handler.defaults)
+
+	// results which cannot be sent back are reported rather than dropped
+	_, hasResult, err = invoke(ctx, handler, &InvocationMessage{Target: "Pair"})
+	assert.False(t, hasResult)
+	assert.Equal(t, InvocationResultError{
+		Target: "Pair",
+		Reason: "expected nothing, an error, a result, or a result and an error, but got (int, int)",
+	}, err)
+}
+
+func TestChainInterceptors_RecoversPanics(t *testing.T) {
+	ctx := context.Background()
+	invoker := chainInterceptors(new(benchHandler), nil)
+
+	// a null argument leaves the pointer nil, so the method dereferences nil
+	_, hasResult, err := invoker(ctx, &InvocationMessage{Target: "Crash", Arguments: benchArgs(t, nil)})
+	assert.False(t, hasResult)
+	require.IsType(t, HandlerPanicError{}, err)
+	hpe := err.(HandlerPanicError)
+	assert.Equal(t, "Crash", hpe.Target)
+	assert.Contains(t, string(hpe.Stack), "(*benchHandler).Crash")
+
+	invoker = chainInterceptors(new(benchHandler), []Interceptor{
+		func(ctx context.Context, msg *InvocationMessage, next Invoker) (interface{}, bool, error) {
+			panic("boom")
+		},
+	})
+	_, _, err = invoker(ctx, &InvocationMessage{Target: "Ping"})
+	require.IsType(t, HandlerPanicError{}, err)
+	assert.Equal(t, "boom", err.(HandlerPanicError).Value)
+	assert.Equal(t, `handler for target "Ping" panicked: boom`, err.Error())
+}
+
+func BenchmarkInvoke_NoArguments(b *testing.B) {
+	benchmarkInvoke(b, &InvocationMessage{Target: "Ping"})
+}
+
+func BenchmarkInvoke_Arguments(b *testing.B) {
+	benchmarkInvoke(b, &InvocationMessage{Target: "Update", Arguments: benchArgs(b, "1", benchObject{Name: "one", Count: 1})})
+}
+
+func BenchmarkInvoke_Result(b *testing.B) {
+	benchmarkInvoke(b, &InvocationMessage{Target: "Add", Arguments: benchArgs(b, 1, 2)})
+}
+
+func BenchmarkInvoke_Default(b *testing.B) {
+	benchmarkInvoke(b, &InvocationMessage{Target: "Missing"})
+}
+
+func benchmarkInvoke(b *testing.B, msg *InvocationMessage) {
+	handler := new(benchHandler)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := invoke(ctx, handler, msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchArgs(tb testing.TB, args ...interface{}) []json.RawMessage {
+	raw := make([]json.RawMessage, len(args))
+	for i, arg := range args {
+		bits, err := json.Marshal(arg)
+		require.NoError(tb, err)
+		raw[i] = bits
+	}
+	return raw
+}
@@ -0,0 +1,855 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// HubServer hosts a hub without the Azure SignalR service, implementing the server side of the ASP.NET Core SignalR
+	// protocol so JavaScript, .NET and other standard SignalR clients can connect to it directly. Mount it as an
+	// `http.Handler` at the hub URL; clients negotiate at the hub URL followed by /negotiate.
+	//
+	// Invocations from clients are dispatched to the handler like invocations received by `Client.Listen`. When the
+	// client awaits the result of an invocation, the value or error returned by the handler method is sent back as a
+	// completion. The calling connection is available from the handler's context with `HubConnectionFromContext`.
+	HubServer struct {
+		handler           Handler
+		userID            UserIDFunc
+		keepAliveInterval time.Duration
+		clientTimeout     time.Duration
+		pollTimeout       time.Duration
+		interceptors      []Interceptor
+		invoker           Invoker
+
+		hubName     string
+		backplane   Backplane
+		unsubscribe func()
+
+		mu          sync.RWMutex
+		tokens      map[string]*hubConn
+		connections map[string]*hubConn
+	}
+
+	// HubServerOption provides a way to configure a hub server at time of construction
+	HubServerOption func(*HubServer) error
+
+	// HubLifetimeHandler is a handler which is also notified when clients connect to and disconnect from a `HubServer`
+	HubLifetimeHandler interface {
+		Handler
+		// OnConnected is called once a client has completed the handshake. Returning an error closes the connection.
+		OnConnected(ctx context.Context, conn *HubConnection) error
+		// OnDisconnected is called once a client has disconnected, with the error which ended the connection if any
+		OnDisconnected(ctx context.Context, conn *HubConnection, err error)
+	}
+
+	// HubConnection describes a client connected to a `HubServer`
+	HubConnection struct {
+		ID     string
+		UserID string
+		// Query and Header are those of the request which negotiated the connection
+		Query  url.Values
+		Header http.Header
+	}
+
+	hubConnectionKey struct{}
+
+	// hubConn is the server side state of a client connection. Its transport delivers the records queued on out and
+	// passes the payloads it receives to `HubServer.receive`.
+	hubConn struct {
+		// lastSeen is the unix nano time the client was last heard from; it is first so it is 64-bit aligned for atomic
+		// access on 32-bit platforms
+		lastSeen int64
+		// polling is the number of outstanding long polls
+		polling int32
+
+		info   *HubConnection
+		token  string
+		out    chan []byte
+		done   chan struct{}
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		closeOnce sync.Once
+
+		mu         sync.Mutex
+		transport  transportTypes
+		handshaken bool
+		buf        []byte
+	}
+
+	hubNegotiateResponse struct {
+		ConnectionID        string               `json:"connectionId"`
+		ConnectionToken     string               `json:"connectionToken,omitempty"`
+		NegotiateVersion    int                  `json:"negotiateVersion"`
+		AvailableTransports []availableTransport `json:"availableTransports"`
+	}
+
+	availableTransport struct {
+		Transport       string   `json:"transport"`
+		TransferFormats []string `json:"transferFormats"`
+	}
+
+	// backplaneMessage is published to the backplane to send a record to, or close, connections on any node
+	backplaneMessage struct {
+		All           bool     `json:"all,omitempty"`
+		ConnectionIDs []string `json:"connectionIds,omitempty"`
+		Record        []byte   `json:"record,omitempty"`
+		Close         bool     `json:"close,omitempty"`
+		Reason        string   `json:"reason,omitempty"`
+	}
+
+	hubMessage struct {
+		Type messageType `json:"type"`
+	}
+
+	hubCloseMessage struct {
+		Type           messageType `json:"type"`
+		Error          string      `json:"error,omitempty"`
+		AllowReconnect bool        `json:"allowReconnect,omitempty"`
+	}
+)
+
+const (
+	// DefaultKeepAliveInterval is how often a hub server pings idle clients
+	DefaultKeepAliveInterval = 15 * time.Second
+	// DefaultClientTimeout is how long a hub server waits to hear from a client before closing its connection
+	DefaultClientTimeout = 30 * time.Second
+	// DefaultLongPollTimeout is how long a hub server holds a long polling request open waiting for messages
+	DefaultLongPollTimeout = 90 * time.Second
+
+	// hubConnBufferSize is the number of records queued for a client before sends to it block
+	hubConnBufferSize = 128
+	// hubSendTimeout is how long delivering a message from the backplane waits for room in a client's queue
+	hubSendTimeout = 5 * time.Second
+	// defaultHubName is the name hubs are shared under in the backplane unless another is configured
+	defaultHubName = "hub"
+)
+
+var (
+	errHubConnClosed = errors.New("the connection is closed")
+)
+
+// HubServerWithUserIDFunc configures how the user of a connection is identified from the request negotiating it.
+// Requests for which it returns an error are rejected with 401 Unauthorized.
+func HubServerWithUserIDFunc(userID UserIDFunc) HubServerOption {
+	return func(s *HubServer) error {
+		if userID == nil {
+			return errors.New("user ID func must not be nil")
+		}
+		s.userID = userID
+		return nil
+	}
+}
+
+// HubServerWithInterceptors adds interceptors run around every invocation from clients dispatched to the handler.
+// Interceptors run in the order they are added.
+func HubServerWithInterceptors(interceptors ...Interceptor) HubServerOption {
+	return func(s *HubServer) error {
+		for _, interceptor := range interceptors {
+			if interceptor == nil {
+				return errors.New("interceptor must not be nil")
+			}
+		}
+		s.interceptors = append(s.interceptors, interceptors...)
+		return nil
+	}
+}
+
+// HubServerWithKeepAliveInterval configures how often the hub server pings clients
+func HubServerWithKeepAliveInterval(interval time.Duration) HubServerOption {
+	return func(s *HubServer) error {
+		if interval <= 0 {
+			return errors.New("keep alive interval must be positive")
+		}
+		s.keepAliveInterval = interval
+		return nil
+	}
+}
+
+// HubServerWithClientTimeout configures how long the hub server waits to hear from a client, or for a negotiated
+// client to connect, before closing its connection
+func HubServerWithClientTimeout(timeout time.Duration) HubServerOption {
+	return func(s *HubServer) error {
+		if timeout <= 0 {
+			return errors.New("client timeout must be positive")
+		}
+		s.clientTimeout = timeout
+		return nil
+	}
+}
+
+// HubServerWithLongPollTimeout configures how long long polling requests are held open waiting for messages
+func HubServerWithLongPollTimeout(timeout time.Duration) HubServerOption {
+	return func(s *HubServer) error {
+		if timeout <= 0 {
+			return errors.New("long poll timeout must be positive")
+		}
+		s.pollTimeout = timeout
+		return nil
+	}
+}
+
+// HubServerWithBackplane configures the backplane the hub server shares messages, groups and presence through, so
+// several hub servers can serve the hub behind a load balancer. Hub servers sharing a backplane serve the same hub if
+// they have the same hub name. By default each hub server has its own in-memory backplane.
+func HubServerWithBackplane(hubName string, backplane Backplane) HubServerOption {
+	return func(s *HubServer) error {
+		if hubName == "" {
+			return errors.New("hub name must not be empty")
+		}
+		if backplane == nil {
+			return errors.New("backplane must not be nil")
+		}
+		s.hubName = hubName
+		s.backplane = backplane
+		return nil
+	}
+}
+
+// NewHubServer creates a hub server dispatching invocations from clients to the handler. If the handler implements
+// `HubLifetimeHandler` it is notified as clients connect and disconnect.
+func NewHubServer(handler Handler, opts ...HubServerOption) (*HubServer, error) {
+	if handler == nil {
+		return nil, errors.New("handler must not be nil")
+	}
+
+	s := &HubServer{
+		handler: handler,
+		userID: func(*http.Request) (string, error) {
+			return "", nil
+		},
+		keepAliveInterval: DefaultKeepAliveInterval,
+		clientTimeout:     DefaultClientTimeout,
+		pollTimeout:       DefaultLongPollTimeout,
+		hubName:           defaultHubName,
+		backplane:         NewMemoryBackplane(),
+		tokens:            make(map[string]*hubConn),
+		connections:       make(map[string]*hubConn),
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	s.invoker = chainInterceptors(handler, s.interceptors)
+
+	unsubscribe, err := s.backplane.Subscribe(context.Background(), s.key(), s.deliver)
+	if err != nil {
+		return nil, err
+	}
+	s.unsubscribe = unsubscribe
+	return s, nil
+}
+
+// HubConnectionFromContext returns the connection which made the invocation being handled, if any
+func HubConnectionFromContext(ctx context.Context) (*HubConnection, bool) {
+	conn, ok := ctx.Value(hubConnectionKey{}).(*HubConnection)
+	return conn, ok
+}
+
+func (s *HubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/negotiate") {
+		s.negotiate(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.connect(w, r)
+	case http.MethodPost:
+		s.post(w, r)
+	case http.MethodDelete:
+		s.delete(w, r)
+	default:
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPost, http.MethodDelete}, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// Close closes every connection to this node and stops receiving messages from the backplane
+func (s *HubServer) Close() {
+	s.unsubscribe()
+
+	s.mu.RLock()
+	conns := make([]*hubConn, 0, len(s.tokens))
+	for _, c := range s.tokens {
+		conns = append(conns, c)
+	}
+	s.mu.RUnlock()
+
+	for _, c := range conns {
+		s.close(c, hubCloseMessage{Type: closeMessageType}, nil)
+	}
+}
+
+// Broadcast will send an `InvocationMessage` to all connections on the hub
+func (s *HubServer) Broadcast(ctx context.Context, msg *InvocationMessage) error {
+	return s.publish(ctx, msg, backplaneMessage{All: true})
+}
+
+// SendToUser will send an `InvocationMessage` to all connections of a particular user
+func (s *HubServer) SendToUser(ctx context.Context, msg *InvocationMessage, userID string) error {
+	ids, err := s.backplane.SetMembers(ctx, s.key("users", userID, "connections"))
+	if err != nil {
+		return err
+	}
+	return s.publish(ctx, msg, backplaneMessage{ConnectionIDs: ids})
+}
+
+// SendToGroup will send an `InvocationMessage` to all connections in a group
+func (s *HubServer) SendToGroup(ctx context.Context, msg *InvocationMessage, groupName string) error {
+	ids, err := s.groupConnections(ctx, groupName)
+	if err != nil {
+		return err
+	}
+	return s.publish(ctx, msg, backplaneMessage{ConnectionIDs: ids})
+}
+
+// SendToConnection will send an `InvocationMessage` to a single connection
+func (s *HubServer) SendToConnection(ctx context.Context, msg *InvocationMessage, connectionID string) error {
+	return s.publish(ctx, msg, backplaneMessage{ConnectionIDs: []string{connectionID}})
+}
+
+// AddUserToGroup will add a user to a group. Current and future connections of the user are members of the group.
+func (s *HubServer) AddUserToGroup(ctx context.Context, groupName string, userID string) error {
+	if err := s.backplane.SetAdd(ctx, s.key("groups", groupName, "users"), userID); err != nil {
+		return err
+	}
+	return s.backplane.SetAdd(ctx, s.key("users", userID, "groups"), groupName)
+}
+
+// RemoveUserFromGroup will remove a user from a group
+func (s *HubServer) RemoveUserFromGroup(ctx context.Context, groupName string, userID string) error {
+	if err := s.backplane.SetRemove(ctx, s.key("groups", groupName, "users"), userID); err != nil {
+		return err
+	}
+	return s.backplane.SetRemove(ctx, s.key("users", userID, "groups"), groupName)
+}
+
+// RemoveUserFromAllGroups will remove a user from all groups
+func (s *HubServer) RemoveUserFromAllGroups(ctx context.Context, userID string) error {
+	return s.leaveAll(ctx, "users", userID)
+}
+
+// AddConnectionToGroup will add a connection to a group
+func (s *HubServer) AddConnectionToGroup(ctx context.Context, groupName string, connectionID string) error {
+	exists, err := s.ConnectionExists(ctx, connectionID)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return fmt.Errorf("connection %q is not connected to the hub", connectionID)
+	}
+
+	if err := s.backplane.SetAdd(ctx, s.key("groups", groupName, "connections"), connectionID); err != nil {
+		return err
+	}
+	return s.backplane.SetAdd(ctx, s.key("connections", connectionID, "groups"), groupName)
+}
+
+// RemoveConnectionFromGroup will remove a connection from a group
+func (s *HubServer) RemoveConnectionFromGroup(ctx context.Context, groupName string, connectionID string) error {
+	if err := s.backplane.SetRemove(ctx, s.key("groups", groupName, "connections"), connectionID); err != nil {
+		return err
+	}
+	return s.backplane.SetRemove(ctx, s.key("connections", connectionID, "groups"), groupName)
+}
+
+// RemoveConnectionFromAllGroups will remove a connection from all groups
+func (s *HubServer) RemoveConnectionFromAllGroups(ctx context.Context, connectionID string) error {
+	return s.leaveAll(ctx, "connections", connectionID)
+}
+
+// CloseConnection will close a client connection, optionally providing a reason to the client
+func (s *HubServer) CloseConnection(ctx context.Context, connectionID string, reason string) error {
+	bits, err := json.Marshal(backplaneMessage{
+		ConnectionIDs: []string{connectionID},
+		Close:         true,
+		Reason:        reason,
+	})
+	if err != nil {
+		return err
+	}
+	return s.backplane.Publish(ctx, s.key(), bits)
+}
+
+// ConnectionExists checks if a connection is currently connected to the hub
+func (s *HubServer) ConnectionExists(ctx context.Context, connectionID string) (bool, error) {
+	return s.backplane.SetIsMember(ctx, s.key("connections"), connectionID)
+}
+
+// UserExists checks if a user has any connections to the hub
+func (s *HubServer) UserExists(ctx context.Context, userID string) (bool, error) {
+	ids, err := s.backplane.SetMembers(ctx, s.key("users", userID, "connections"))
+	return len(ids) > 0, err
+}
+
+// GroupExists checks if a group has any connections
+func (s *HubServer) GroupExists(ctx context.Context, groupName string) (bool, error) {
+	ids, err := s.groupConnections(ctx, groupName)
+	return len(ids) > 0, err
+}
+
+// key builds the backplane key or channel of the hub from the parts, escaping each so names containing ':' cannot
+// collide with the keys of other parts or hubs
+func (s *HubServer) key(parts ...string) string {
+	escaped := make([]string, 0, len(parts)+2)
+	escaped = append(escaped, "signalr", url.QueryEscape(s.hubName))
+	for _, part := range parts {
+		escaped = append(escaped, url.QueryEscape(part))
+	}
+	return strings.Join(escaped, ":")
+}
+
+// groupConnections returns the connections in the group, either directly or through their user
+func (s *HubServer) groupConnections(ctx context.Context, groupName string) ([]string, error) {
+	ids, err := s.backplane.SetMembers(ctx, s.key("groups", groupName, "connections"))
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.backplane.SetMembers(ctx, s.key("groups", groupName, "users"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, userID := range users {
+		userIDs, err := s.backplane.SetMembers(ctx, s.key("users", userID, "connections"))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, userIDs...)
+	}
+	return ids, nil
+}
+
+// leaveAll removes the user or connection from every group it is a member of
+func (s *HubServer) leaveAll(ctx context.Context, kind, member string) error {
+	groups, err := s.backplane.SetMembers(ctx, s.key(kind, member, "groups"))
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		if err := s.backplane.SetRemove(ctx, s.key("groups", group, kind), member); err != nil {
+			return err
+		}
+	}
+	return s.backplane.Delete(ctx, s.key(kind, member, "groups"))
+}
+
+// publish sends the invocation to the connections selected by the message on every node
+func (s *HubServer) publish(ctx context.Context, msg *InvocationMessage, bm backplaneMessage) error {
+	if !bm.All && len(bm.ConnectionIDs) == 0 {
+		return nil
+	}
+
+	args := msg.Arguments
+	if args == nil {
+		args = []json.RawMessage{}
+	}
+
+	bits, err := json.Marshal(InvocationMessage{
+		Type:      invocationMessageType,
+		Headers:   msg.Headers,
+		Target:    msg.Target,
+		Arguments: args,
+	})
+	if err != nil {
+		return err
+	}
+	bm.Record = append(bits, messageTerminator)
+
+	bits, err = json.Marshal(bm)
+	if err != nil {
+		return err
+	}
+	return s.backplane.Publish(ctx, s.key(), bits)
+}
+
+// deliver sends a message published to the backplane to the connections of this node it is for
+func (s *HubServer) deliver(message []byte) {
+	var bm backplaneMessage
+	if err := json.Unmarshal(message, &bm); err != nil {
+		return
+	}
+
+	var targets []*hubConn
+	s.mu.RLock()
+	if bm.All {
+		for _, c := range s.connections {
+			targets = append(targets, c)
+		}
+	}
+	for _, id := range bm.ConnectionIDs {
+		if c, ok := s.connections[id]; ok {
+			targets = append(targets, c)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, c := range targets {
+		if bm.Close {
+			s.close(c, hubCloseMessage{Type: closeMessageType, Error: bm.Reason}, nil)
+			continue
+		}
+		// a client whose queue stays full for the send timeout misses the message rather than holding up the
+		// backplane
+		ctx, cancel := context.WithTimeout(c.ctx, hubSendTimeout)
+		_ = c.send(ctx, bm.Record)
+		cancel()
+	}
+}
+
+// join records the connection in the backplane once it has completed the handshake
+func (s *HubServer) join(ctx context.Context, c *hubConn) error {
+	if err := s.backplane.SetAdd(ctx, s.key("connections"), c.info.ID); err != nil {
+		return err
+	}
+
+	if c.info.UserID != "" {
+		return s.backplane.SetAdd(ctx, s.key("users", c.info.UserID, "connections"), c.info.ID)
+	}
+	return nil
+}
+
+// leave removes the connection from the backplane
+func (s *HubServer) leave(ctx context.Context, c *hubConn) {
+	_ = s.backplane.SetRemove(ctx, s.key("connections"), c.info.ID)
+	if c.info.UserID != "" {
+		_ = s.backplane.SetRemove(ctx, s.key("users", c.info.UserID, "connections"), c.info.ID)
+	}
+	_ = s.leaveAll(ctx, "connections", c.info.ID)
+}
+
+func (s *HubServer) negotiate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	c, err := s.newConn(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	res := hubNegotiateResponse{
+		ConnectionID: c.info.ID,
+		AvailableTransports: []availableTransport{
+			{Transport: string(websocketTransportType), TransferFormats: []string{"Text", "Binary"}},
+			{Transport: string(serverSentEventsTransportType), TransferFormats: []string{"Text"}},
+			{Transport: string(longPollingTransportType), TransferFormats: []string{"Text", "Binary"}},
+		},
+	}
+
+	// clients of negotiate version 1 connect with a connection token which, unlike the connection ID, is not shared
+	// with other clients
+	if version, _ := strconv.Atoi(r.URL.Query().Get("negotiateVersion")); version >= 1 {
+		res.NegotiateVersion = 1
+		res.ConnectionToken = uuid.Must(uuid.NewRandom()).String()
+		c.token = res.ConnectionToken
+	}
+
+	s.mu.Lock()
+	s.tokens[c.token] = c
+	s.mu.Unlock()
+
+	// connections which are negotiated but never started are abandoned once the client timeout has passed
+	time.AfterFunc(s.clientTimeout, func() {
+		c.mu.Lock()
+		started := c.transport != ""
+		c.mu.Unlock()
+		if !started {
+			s.disconnect(c, errors.New("the connection was negotiated but never started"))
+		}
+	})
+
+	bits, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	_, _ = w.Write(bits)
+}
+
+// newConn creates a connection for the user identified from the request
+func (s *HubServer) newConn(r *http.Request) (*hubConn, error) {
+	userID, err := s.userID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.Must(uuid.NewRandom()).String()
+	info := &HubConnection{
+		ID:     id,
+		UserID: userID,
+		Query:  r.URL.Query(),
+		Header: make(http.Header, len(r.Header)),
+	}
+	for key, values := range r.Header {
+		info.Header[key] = append([]string(nil), values...)
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), hubConnectionKey{}, info))
+	c := &hubConn{
+		info:     info,
+		token:    id,
+		out:      make(chan []byte, hubConnBufferSize),
+		done:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+		lastSeen: time.Now().UnixNano(),
+	}
+	return c, nil
+}
+
+// lookup finds the connection a transport request is for
+func (s *HubServer) lookup(w http.ResponseWriter, r *http.Request) (*hubConn, bool) {
+	token := r.URL.Query().Get("id")
+	if token == "" {
+		http.Error(w, "connection ID required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	s.mu.RLock()
+	c, ok := s.tokens[token]
+	s.mu.RUnlock()
+
+	if !ok {
+		http.Error(w, "no connection with that ID", http.StatusNotFound)
+		return nil, false
+	}
+	return c, true
+}
+
+// startTransport binds the connection to the transport, returning false if it is already bound to another
+func (c *hubConn) startTransport(transport transportTypes) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.transport != "" {
+		return false
+	}
+	c.transport = transport
+	return true
+}
+
+// receive processes a payload from the client, which may contain several records and end partway through a record
+func (s *HubServer) receive(c *hubConn, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+	c.buf = append(c.buf, payload...)
+	for {
+		i := bytes.IndexByte(c.buf, messageTerminator)
+		if i < 0 {
+			return
+		}
+
+		record := c.buf[:i]
+		c.buf = append([]byte(nil), c.buf[i+1:]...)
+
+		if !c.handshaken {
+			if err := s.handshake(c, record); err != nil {
+				s.disconnect(c, err)
+				return
+			}
+			continue
+		}
+
+		if err := s.handleRecord(c, record); err != nil {
+			s.close(c, hubCloseMessage{Type: closeMessageType, Error: "Connection closed with an error."}, err)
+			return
+		}
+	}
+}
+
+// handshake agrees the protocol with the client, then starts tracking the connection; c.mu must be held
+func (s *HubServer) handshake(c *hubConn, record []byte) error {
+	var req handshakeRequest
+	if err := json.Unmarshal(record, &req); err != nil {
+		return err
+	}
+
+	if req.Protocol != "json" || req.Version != 1 {
+		msg := fmt.Sprintf("The protocol '%s' version %d is not supported.", req.Protocol, req.Version)
+		_ = c.sendMessage(handshakeResponse{Error: msg})
+		return errors.New(msg)
+	}
+
+	if err := c.sendMessage(handshakeResponse{}); err != nil {
+		return err
+	}
+	c.handshaken = true
+
+	// the connection is tracked on this node before it joins the backplane so no message for it is missed
+	s.mu.Lock()
+	s.connections[c.info.ID] = c
+	s.mu.Unlock()
+
+	if err := s.join(c.ctx, c); err != nil {
+		return err
+	}
+
+	go s.keepAlive(c)
+
+	if h, ok := s.handler.(HubLifetimeHandler); ok {
+		return h.OnConnected(c.ctx, c.info)
+	}
+	return nil
+}
+
+// handleRecord dispatches a hub protocol message from the client; c.mu must be held so invocations run in order
+func (s *HubServer) handleRecord(c *hubConn, record []byte) error {
+	var msg InvocationMessage
+	if err := json.Unmarshal(record, &msg); err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case invocationMessageType:
+		result, hasResult, err := s.invoker(c.ctx, &msg)
+		if msg.InvocationID == "" {
+			return nil
+		}
+		return s.complete(c, &msg, result, hasResult, err)
+	case streamInvocationMessageType:
+		return s.complete(c, &msg, nil, false, UpstreamError{Message: "Streaming invocations are not supported."})
+	case pingMessageType, cancelInvocationMessageType, streamItemMessageType, completionMessageType:
+		return nil
+	case closeMessageType:
+		s.disconnect(c, nil)
+		return nil
+	}
+	return fmt.Errorf("unknown message type: %d", msg.Type)
+}
+
+// complete sends the completion of an invocation to the client
+func (s *HubServer) complete(c *hubConn, msg *InvocationMessage, result interface{}, hasResult bool, invokeErr error) error {
+	completion, err := newCompletionMessage(msg, result, hasResult, invokeErr)
+	if err != nil {
+		return err
+	}
+
+	record, err := completion.jsonRecord()
+	if err != nil {
+		return err
+	}
+	return c.send(c.ctx, record)
+}
+
+// keepAlive pings the client and closes the connection once the client has not been heard from within the timeout
+func (s *HubServer) keepAlive(c *hubConn) {
+	ticker := time.NewTicker(s.keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if !c.alive() && time.Since(time.Unix(0, atomic.LoadInt64(&c.lastSeen))) > s.clientTimeout {
+				s.close(c, hubCloseMessage{Type: closeMessageType, Error: "Connection timed out."},
+					errors.New("the client did not send a message within the client timeout"))
+				return
+			}
+			_ = c.sendMessage(hubMessage{Type: pingMessageType})
+		}
+	}
+}
+
+// close sends the close message to the client and disconnects it
+func (s *HubServer) close(c *hubConn, msg hubCloseMessage, err error) {
+	_ = c.sendMessage(msg)
+	s.disconnect(c, err)
+}
+
+// disconnect stops tracking the connection and signals its transport to finish. It does not take c.mu, so handlers may
+// close the connection they are invoked on.
+func (s *HubServer) disconnect(c *hubConn, err error) {
+	c.closeOnce.Do(func() {
+		s.mu.Lock()
+		_, connected := s.connections[c.info.ID]
+		delete(s.tokens, c.token)
+		delete(s.connections, c.info.ID)
+		s.mu.Unlock()
+
+		c.cancel()
+		close(c.done)
+		s.leave(context.Background(), c)
+
+		if h, ok := s.handler.(HubLifetimeHandler); ok && connected {
+			h.OnDisconnected(context.WithValue(context.Background(), hubConnectionKey{}, c.info), c.info, err)
+		}
+	})
+}
+
+// sendMessage queues a single JSON record for the client without waiting if the queue is full
+func (c *hubConn) sendMessage(msg interface{}) error {
+	bits, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.done:
+		return errHubConnClosed
+	default:
+	}
+
+	select {
+	case c.out <- append(bits, messageTerminator):
+		return nil
+	default:
+		return errors.New("the connection's send queue is full")
+	}
+}
+
+// send queues the record for the client, waiting for room in the queue
+func (c *hubConn) send(ctx context.Context, record []byte) error {
+	select {
+	case <-c.done:
+		return errHubConnClosed
+	default:
+	}
+
+	select {
+	case c.out <- record:
+		return nil
+	case <-c.done:
+		return errHubConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain returns the records queued for the client without waiting
+func (c *hubConn) drain() []byte {
+	var payload []byte
+	for {
+		select {
+		case record := <-c.out:
+			payload = append(payload, record...)
+		default:
+			return payload
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package signalr_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+
+	"github.com/devigned/signalr-go"
+)
+
+type (
+	chatHub struct {
+		server       *signalr.HubServer
+		connected    chan *signalr.HubConnection
+		disconnected chan error
+	}
+)
+
+func (h *chatHub) Default(ctx context.Context, target string, args []json.RawMessage) error {
+	return nil
+}
+
+func (h *chatHub) Add(ctx context.Context, a, b int) int {
+	return a + b
+}
+
+func (h *chatHub) Fail(ctx context.Context) error {
+	return signalr.UpstreamError{Message: "no can do"}
+}
+
+func (h *chatHub) Whoami(ctx context.Context) (string, error) {
+	conn, ok := signalr.HubConnectionFromContext(ctx)
+	if !ok {
+		return "", errors.New("no connection in context")
+	}
+	return conn.UserID, nil
+}
+
+func (h *chatHub) Join(ctx context.Context, group string) error {
+	conn, _ := signalr.HubConnectionFromContext(ctx)
+	return h.server.AddConnectionToGroup(ctx, group, conn.ID)
+}
+
+func (h *chatHub) OnConnected(ctx context.Context, conn *signalr.HubConnection) error {
+	h.connected <- conn
+	return nil
+}
+
+func (h *chatHub) OnDisconnected(ctx context.Context, conn *signalr.HubConnection, err error) {
+	h.disconnected <- err
+}
+
+func newChatHub(t *testing.T, opts ...signalr.HubServerOption) (*chatHub, *httptest.Server) {
+	hub := &chatHub{
+		connected:    make(chan *signalr.HubConnection, 10),
+		disconnected: make(chan error, 10),
+	}
+
+	opts = append([]signalr.HubServerOption{signalr.HubServerWithUserIDFunc(func(r *http.Request) (string, error) {
+		return r.URL.Query().Get("user"), nil
+	})}, opts...)
+
+	server, err := signalr.NewHubServer(hub, opts...)
+	require.NoError(t, err)
+	hub.server = server
+
+	mux := http.NewServeMux()
+	mux.Handle("/chat", server)
+	mux.Handle("/chat/negotiate", server)
+	return hub, httptest.NewServer(mux)
+}
+
+func record(t *testing.T, msg interface{}) []byte {
+	bits, err := json.Marshal(msg)
+	require.NoError(t, err)
+	return append(bits, 0x1E)
+}
+
+func records(t *testing.T, payload []byte) []map[string]interface{} {
+	var msgs []map[string]interface{}
+	for _, bits := range bytes.Split(payload, []byte{0x1E}) {
+		if len(bits) == 0 {
+			continue
+		}
+		var msg map[string]interface{}
+		require.NoError(t, json.Unmarshal(bits, &msg))
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func dialHub(ctx context.Context, t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.Dial(ctx, strings.Replace(url, "http://", "ws://", 1), websocket.DialOptions{})
+	require.NoError(t, err)
+	return conn
+}
+
+func writeHub(ctx context.Context, t *testing.T, conn *websocket.Conn, msg interface{}) {
+	writer, err := conn.Writer(ctx, websocket.MessageText)
+	require.NoError(t, err)
+	_, err = writer.Write(record(t, msg))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+}
+
+func readHub(ctx context.Context, t *testing.T, conn *websocket.Conn) []map[string]interface{} {
+	_, reader, err := conn.Reader(ctx)
+	require.NoError(t, err)
+	payload, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	return records(t, payload)
+}
+
+func negotiateHub(t *testing.T, url, query string) map[string]interface{} {
+	res, err := http.Post(url+"/negotiate?negotiateVersion=1&"+query, "text/plain", nil)
+	require.NoError(t, err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	return body
+}
+
+func TestHubServer_WebSockets(t *testing.T) {
+	hub, ts := newChatHub(t)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	negotiated := negotiateHub(t, ts.URL+"/chat", "")
+	assert.Equal(t, 1.0, negotiated["negotiateVersion"])
+	assert.Len(t, negotiated["availableTransports"], 3)
+
+	conn := dialHub(ctx, t, ts.URL+"/chat?id="+negotiated["connectionToken"].(string))
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	writeHub(ctx, t, conn, map[string]interface{}{"protocol": "json", "version": 1})
+	assert.Equal(t, []map[string]interface{}{{}}, readHub(ctx, t, conn))
+	connected := <-hub.connected
+	assert.Equal(t, negotiated["connectionId"], connected.ID)
+
+	writeHub(ctx, t, conn, map[string]interface{}{"type": 1, "invocationId": "1", "target": "Add", "arguments": []int{1, 2}})
+	assert.Equal(t, []map[string]interface{}{{"type": 3.0, "invocationId": "1", "result": 3.0}}, readHub(ctx, t, conn))
+
+	writeHub(ctx, t, conn, map[string]interface{}{"type": 1, "invocationId": "2", "target": "Fail", "arguments": []int{}})
+	assert.Equal(t, []map[string]interface{}{{"type": 3.0, "invocationId": "2", "error": "no can do"}}, readHub(ctx, t, conn))
+
+	writeHub(ctx, t, conn, map[string]interface{}{"type": 1, "invocationId": "3", "target": "Join", "arguments": []string{"room"}})
+	assert.Equal(t, []map[string]interface{}{{"type": 3.0, "invocationId": "3"}}, readHub(ctx, t, conn))
+	exists, err := hub.server.GroupExists(ctx, "room")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	msg, err := signalr.NewInvocationMessage("Greet", "hello")
+	require.NoError(t, err)
+	require.NoError(t, hub.server.SendToGroup(ctx, msg, "lobby"))
+	require.NoError(t, hub.server.SendToGroup(ctx, msg, "room"))
+	assert.Equal(t, []map[string]interface{}{{"type": 1.0, "target": "Greet", "arguments": []interface{}{"hello"}}}, readHub(ctx, t, conn))
+
+	require.NoError(t, hub.server.CloseConnection(ctx, connected.ID, "bye"))
+	assert.Equal(t, []map[string]interface{}{{"type": 7.0, "error": "bye"}}, readHub(ctx, t, conn))
+	assert.NoError(t, <-hub.disconnected)
+
+	exists, err = hub.server.ConnectionExists(ctx, connected.ID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestHubServer_UnsupportedProtocol(t *testing.T) {
+	_, ts := newChatHub(t)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn := dialHub(ctx, t, ts.URL+"/chat")
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	writeHub(ctx, t, conn, map[string]interface{}{"protocol": "messagepack", "version": 1})
+	res := readHub(ctx, t, conn)
+	require.Len(t, res, 1)
+	assert.Contains(t, res[0]["error"], "messagepack")
+}
+
+func TestHubServer_ClientTimeout(t *testing.T) {
+	hub, ts := newChatHub(t,
+		signalr.HubServerWithKeepAliveInterval(20*time.Millisecond),
+		signalr.HubServerWithClientTimeout(100*time.Millisecond))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn := dialHub(ctx, t, ts.URL+"/chat")
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	writeHub(ctx, t, conn, map[string]interface{}{"protocol": "json", "version": 1})
+	<-hub.connected
+
+	select {
+	case err := <-hub.disconnected:
+		assert.Error(t, err)
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
+
+func TestHubServer_ServerSentEvents(t *testing.T) {
+	hub, ts := newChatHub(t)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	url := ts.URL + "/chat?user=alice&id=" + negotiateHub(t, ts.URL+"/chat", "user=alice")["connectionToken"].(string)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	events := bufio.NewReader(res.Body)
+
+	readEvent := func() []map[string]interface{} {
+		var data []byte
+		for {
+			line, err := events.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" && len(data) > 0 {
+				return records(t, data)
+			}
+			data = append(data, strings.TrimPrefix(line, "data: ")...)
+		}
+	}
+
+	post := func(msg interface{}) {
+		res, err := http.Post(url, "text/plain", bytes.NewReader(record(t, msg)))
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	post(map[string]interface{}{"protocol": "json", "version": 1})
+	assert.Equal(t, []map[string]interface{}{{}}, readEvent())
+	assert.Equal(t, "alice", (<-hub.connected).UserID)
+
+	post(map[string]interface{}{"type": 1, "invocationId": "1", "target": "Whoami", "arguments": []int{}})
+	assert.Equal(t, []map[string]interface{}{{"type": 3.0, "invocationId": "1", "result": "alice"}}, readEvent())
+
+	msg, err := signalr.NewInvocationMessage("Greet", "hello")
+	require.NoError(t, err)
+	require.NoError(t, hub.server.SendToUser(ctx, msg, "bob"))
+	require.NoError(t, hub.server.SendToUser(ctx, msg, "alice"))
+	assert.Equal(t, []map[string]interface{}{{"type": 1.0, "target": "Greet", "arguments": []interface{}{"hello"}}}, readEvent())
+}
+
+func TestHubServer_LongPolling(t *testing.T) {
+	hub, ts := newChatHub(t, signalr.HubServerWithLongPollTimeout(100*time.Millisecond))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	url := ts.URL + "/chat?id=" + negotiateHub(t, ts.URL+"/chat", "")["connectionToken"].(string)
+	poll := func() (int, []byte) {
+		res, err := http.Get(url)
+		require.NoError(t, err)
+		defer func() {
+			_ = res.Body.Close()
+		}()
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, body
+	}
+
+	status, body := poll()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, body)
+
+	res, err := http.Post(url, "text/plain", bytes.NewReader(record(t, map[string]interface{}{"protocol": "json", "version": 1})))
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	<-hub.connected
+
+	status, body = poll()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []map[string]interface{}{{}}, records(t, body))
+
+	// a poll with nothing to deliver times out empty
+	status, body = poll()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, body)
+
+	msg, err := signalr.NewInvocationMessage("Greet", "hello")
+	require.NoError(t, err)
+	require.NoError(t, hub.server.Broadcast(ctx, msg))
+	status, body = poll()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []map[string]interface{}{{"type": 1.0, "target": "Greet", "arguments": []interface{}{"hello"}}}, records(t, body))
+
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	assert.NoError(t, <-hub.disconnected)
+
+	status, _ = poll()
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestHubServer_Client(t *testing.T) {
+	hub := &chatHub{
+		connected:    make(chan *signalr.HubConnection, 10),
+		disconnected: make(chan error, 10),
+	}
+	server, err := signalr.NewHubServer(hub)
+	require.NoError(t, err)
+	hub.server = server
+
+	// the client connects to hubs at /client on the endpoint
+	ts := httptest.NewServer(http.StripPrefix("/client", server))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := signalr.NewClient("Endpoint="+ts.URL+";AccessKey=unused;Version=1.0;", "chat")
+	require.NoError(t, err)
+
+	listenCtx, stop := context.WithCancel(ctx)
+	targets := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Listen(listenCtx, signalr.HandlerFunc(func(ctx context.Context, target string, args []json.RawMessage) error {
+			targets <- target
+			return nil
+		}))
+	}()
+	<-hub.connected
+
+	msg, err := signalr.NewInvocationMessage("Greet", "hello")
+	require.NoError(t, err)
+	require.NoError(t, server.Broadcast(ctx, msg))
+	assert.Equal(t, "Greet", <-targets)
+
+	stop()
+	assert.NoError(t, <-done)
+}
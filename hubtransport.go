@@ -0,0 +1,289 @@
+package signalr
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const (
+	// maxHubPostSize limits the size of the payloads server-sent events and long polling clients post
+	maxHubPostSize = 1 << 20
+)
+
+// connect starts a WebSocket or server-sent events connection, or handles a long poll
+func (s *HubServer) connect(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		s.serveWebSocket(w, r)
+		return
+	}
+
+	c, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.serveServerSentEvents(w, r, c)
+		return
+	}
+	s.poll(w, r, c)
+}
+
+// post receives a payload from a server-sent events or long polling client
+func (s *HubServer) post(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	transport := c.transport
+	c.mu.Unlock()
+
+	if transport != serverSentEventsTransportType && transport != longPollingTransportType {
+		http.Error(w, "the connection does not receive messages by POST", http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxHubPostSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.receive(c, body)
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete ends a long polling connection
+func (s *HubServer) delete(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	transport := c.transport
+	c.mu.Unlock()
+
+	if transport != longPollingTransportType {
+		http.Error(w, "only long polling connections can be deleted", http.StatusBadRequest)
+		return
+	}
+
+	s.disconnect(c, nil)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *HubServer) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	var c *hubConn
+	if r.URL.Query().Get("id") == "" {
+		// WebSocket clients may skip negotiation
+		var err error
+		if c, err = s.newConn(r); err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		s.mu.Lock()
+		s.tokens[c.token] = c
+		s.mu.Unlock()
+	} else {
+		var ok bool
+		if c, ok = s.lookup(w, r); !ok {
+			return
+		}
+	}
+
+	if !c.startTransport(websocketTransportType) {
+		http.Error(w, "the connection has already started", http.StatusConflict)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, websocket.AcceptOptions{})
+	if err != nil {
+		s.disconnect(c, err)
+		return
+	}
+
+	ctx := r.Context()
+	go s.writeWebSocket(ctx, conn, c)
+
+	for {
+		_, reader, err := conn.Reader(ctx)
+		if err != nil {
+			if isNormalClosure(err) {
+				err = nil
+			}
+			s.disconnect(c, err)
+			return
+		}
+
+		payload, err := ioutil.ReadAll(reader)
+		if err != nil {
+			s.disconnect(c, err)
+			return
+		}
+		s.receive(c, payload)
+	}
+}
+
+// writeWebSocket sends the records queued for the client until the connection is closed
+func (s *HubServer) writeWebSocket(ctx context.Context, conn *websocket.Conn, c *hubConn) {
+	for {
+		select {
+		case record := <-c.out:
+			if err := writeFrame(ctx, conn, record); err != nil {
+				s.disconnect(c, err)
+				_ = conn.Close(websocket.StatusInternalError, "")
+				return
+			}
+		case <-c.done:
+			if payload := c.drain(); len(payload) > 0 {
+				_ = writeFrame(ctx, conn, payload)
+			}
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+			return
+		}
+	}
+}
+
+func (s *HubServer) serveServerSentEvents(w http.ResponseWriter, r *http.Request, c *hubConn) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	if !c.startTransport(serverSentEventsTransportType) {
+		http.Error(w, "the connection has already started", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case record := <-c.out:
+			if err := writeEvent(w, record); err != nil {
+				s.disconnect(c, err)
+				return
+			}
+			flusher.Flush()
+		case <-c.done:
+			if payload := c.drain(); len(payload) > 0 {
+				_ = writeEvent(w, payload)
+				flusher.Flush()
+			}
+			return
+		case <-r.Context().Done():
+			s.disconnect(c, nil)
+			return
+		}
+	}
+}
+
+// poll responds with the records queued for a long polling client, waiting up to the poll timeout for one to be sent.
+// The first poll starts the connection and returns immediately.
+func (s *HubServer) poll(w http.ResponseWriter, r *http.Request, c *hubConn) {
+	if c.startTransport(longPollingTransportType) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	c.mu.Lock()
+	transport := c.transport
+	c.mu.Unlock()
+
+	if transport != longPollingTransportType {
+		http.Error(w, "the connection has already started", http.StatusConflict)
+		return
+	}
+
+	atomic.AddInt32(&c.polling, 1)
+	defer func() {
+		atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+		atomic.AddInt32(&c.polling, -1)
+	}()
+
+	timer := time.NewTimer(s.pollTimeout)
+	defer timer.Stop()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	select {
+	case record := <-c.out:
+		_, _ = w.Write(append(record, c.drain()...))
+	case <-c.done:
+		if payload := c.drain(); len(payload) > 0 {
+			_, _ = w.Write(payload)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case <-timer.C:
+		w.WriteHeader(http.StatusOK)
+	case <-r.Context().Done():
+	}
+}
+
+// alive reports whether the transport shows the client is still connected. Clients do not ping over server-sent
+// events, which end when the client goes away, nor over long polling while a poll is outstanding.
+func (c *hubConn) alive() bool {
+	c.mu.Lock()
+	transport := c.transport
+	c.mu.Unlock()
+
+	return transport == serverSentEventsTransportType ||
+		(transport == longPollingTransportType && atomic.LoadInt32(&c.polling) > 0)
+}
+
+// writeFrame writes the payload as a single text message
+func writeFrame(ctx context.Context, conn *websocket.Conn, payload []byte) error {
+	writer, err := conn.Writer(ctx, websocket.MessageText)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(payload); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// writeEvent writes the payload as a server-sent event, prefixing each of its lines with the data field name
+func writeEvent(w http.ResponseWriter, payload []byte) error {
+	var event strings.Builder
+	for _, line := range strings.Split(string(payload), "\n") {
+		event.WriteString("data: ")
+		event.WriteString(line)
+		event.WriteString("\r\n")
+	}
+	event.WriteString("\r\n")
+
+	_, err := w.Write([]byte(event.String()))
+	return err
+}
+
+// isNormalClosure reports whether the error is the client closing the WebSocket normally
+func isNormalClosure(err error) bool {
+	for err != nil {
+		if ce, ok := err.(websocket.CloseError); ok {
+			return ce.Code == websocket.StatusNormalClosure || ce.Code == websocket.StatusGoingAway
+		}
+
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package signalr
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+type (
+	// Invoker calls the handler of an invocation. hasResult reports whether the handler returned a result, which is
+	// sent back to the caller if it awaits the result of the invocation.
+	Invoker func(ctx context.Context, msg *InvocationMessage) (result interface{}, hasResult bool, err error)
+
+	// Interceptor runs around the invocation of a handler, seeing the whole invocation message including its headers
+	// and invocation ID. It calls next to continue the invocation, or returns without calling it to stop the invocation,
+	// and may replace the context, message, result or error. Interceptors run in the order they are configured, the
+	// first configured running outermost.
+	Interceptor func(ctx context.Context, msg *InvocationMessage, next Invoker) (result interface{}, hasResult bool, err error)
+)
+
+// chainInterceptors returns an invoker running the interceptors in order around the invocation of the handler. Panics
+// in the handler or the interceptors are recovered and returned as a `HandlerPanicError`.
+func chainInterceptors(handler Handler, interceptors []Interceptor) Invoker {
+	invoker := Invoker(func(ctx context.Context, msg *InvocationMessage) (interface{}, bool, error) {
+		return invoke(ctx, handler, msg)
+	})
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, msg *InvocationMessage) (interface{}, bool, error) {
+			return interceptor(ctx, msg, next)
+		}
+	}
+	return recoverPanics(invoker)
+}
+
+// recoverPanics returns an invoker which converts a panic of the invoker into a `HandlerPanicError`
+func recoverPanics(invoker Invoker) Invoker {
+	return func(ctx context.Context, msg *InvocationMessage) (result interface{}, hasResult bool, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				result, hasResult = nil, false
+				err = HandlerPanicError{Target: msg.Target, Value: r, Stack: debug.Stack()}
+			}
+		}()
+		return invoker(ctx, msg)
+	}
+}
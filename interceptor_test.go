@@ -0,0 +1,98 @@
+package signalr_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+
+	"github.com/devigned/signalr-go"
+)
+
+// tracer returns an interceptor recording when it runs under the name
+func tracer(trace *[]string, name string) signalr.Interceptor {
+	return func(ctx context.Context, msg *signalr.InvocationMessage, next signalr.Invoker) (interface{}, bool, error) {
+		*trace = append(*trace, name+" before "+msg.Target)
+		result, hasResult, err := next(ctx, msg)
+		*trace = append(*trace, name+" after "+msg.Target)
+		return result, hasResult, err
+	}
+}
+
+// authorizer returns an interceptor rejecting invocations without the token in their headers
+func authorizer(token string) signalr.Interceptor {
+	return func(ctx context.Context, msg *signalr.InvocationMessage, next signalr.Invoker) (interface{}, bool, error) {
+		if msg.Headers["token"] != token {
+			return nil, false, signalr.UpstreamError{StatusCode: http.StatusForbidden, Message: "forbidden"}
+		}
+		return next(ctx, msg)
+	}
+}
+
+func TestUpstreamHandler_Interceptors(t *testing.T) {
+	var trace []string
+	handler, err := signalr.NewUpstreamHandler(new(upstreamRecorder),
+		signalr.UpstreamWithInterceptors(tracer(&trace, "outer"), authorizer("secret")),
+		signalr.UpstreamWithInterceptors(tracer(&trace, "inner")))
+	require.NoError(t, err)
+
+	body := `{"type":1,"invocationId":"1","target":"Add","arguments":[1,2],"headers":{"token":"secret"}}` + "\x1e" +
+		`{"type":1,"invocationId":"2","target":"Add","arguments":[1,2]}` + "\x1e"
+	rec := serveUpstream(handler, newUpstreamRequest(signalr.UpstreamCategoryMessages, "Add", body))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	records := strings.Split(strings.TrimSuffix(rec.Body.String(), "\x1e"), "\x1e")
+	require.Len(t, records, 2)
+	assert.JSONEq(t, `{"type":3,"invocationId":"1","result":3}`, records[0])
+	assert.JSONEq(t, `{"type":3,"invocationId":"2","error":"forbidden"}`, records[1])
+	assert.Equal(t, []string{
+		"outer before Add", "inner before Add", "inner after Add", "outer after Add",
+		"outer before Add", "outer after Add",
+	}, trace)
+}
+
+func TestHubServer_Interceptors(t *testing.T) {
+	var trace []string
+	_, ts := newChatHub(t, signalr.HubServerWithInterceptors(
+		tracer(&trace, "outer"),
+		func(ctx context.Context, msg *signalr.InvocationMessage, next signalr.Invoker) (interface{}, bool, error) {
+			// results may be replaced on the way out
+			result, hasResult, err := next(ctx, msg)
+			if n, ok := result.(int); ok {
+				result = n * 10
+			}
+			return result, hasResult, err
+		},
+		tracer(&trace, "inner")))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn := dialHub(ctx, t, ts.URL+"/chat")
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	writeHub(ctx, t, conn, map[string]interface{}{"protocol": "json", "version": 1})
+	assert.Equal(t, []map[string]interface{}{{}}, readHub(ctx, t, conn))
+
+	writeHub(ctx, t, conn, map[string]interface{}{"type": 1, "invocationId": "1", "target": "Add", "arguments": []int{1, 2}})
+	assert.Equal(t, []map[string]interface{}{{"type": 3.0, "invocationId": "1", "result": 30.0}}, readHub(ctx, t, conn))
+	assert.Equal(t, []string{"outer before Add", "inner before Add", "inner after Add", "outer after Add"}, trace)
+}
+
+func TestInterceptors_Nil(t *testing.T) {
+	_, err := signalr.NewUpstreamHandler(new(upstreamRecorder), signalr.UpstreamWithInterceptors(nil))
+	assert.Error(t, err)
+	_, err = signalr.NewHubServer(new(chatHub), signalr.HubServerWithInterceptors(nil))
+	assert.Error(t, err)
+	_, err = signalr.NewClient(os.Getenv("SIGNALR_CONNECTION_STRING"), "hub1", signalr.ClientWithInterceptors(nil))
+	assert.Error(t, err)
+}
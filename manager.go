@@ -0,0 +1,487 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// ServiceManager provides access to the versioned Azure SignalR management REST API for a single hub
+	ServiceManager struct {
+		hubName       string
+		apiVersion    string
+		parsedConnStr *ParsedConnString
+		httpClient    *http.Client
+		concurrency   int
+		tokenProvider TokenProvider
+		clientTokens  *tokenCache
+		tokenLifetime time.Duration
+		notBeforeSkew time.Duration
+	}
+
+	// ServiceManagerOption provides a way to configure a service manager at time of construction
+	ServiceManagerOption func(*ServiceManager) error
+
+	// payloadMessage is the body expected by the versioned REST API send operations
+	payloadMessage struct {
+		Target    string            `json:"target"`
+		Arguments []json.RawMessage `json:"arguments"`
+	}
+)
+
+const (
+	// DefaultAPIVersion is the version of the Azure SignalR management REST API used when none is specified
+	DefaultAPIVersion = "2022-06-01"
+
+	// DefaultBatchConcurrency is the number of concurrent requests used to fan out batch sends when none is specified
+	DefaultBatchConcurrency = 8
+)
+
+// ServiceManagerWithAPIVersion configures the service manager to target a specific version of the management REST API
+func ServiceManagerWithAPIVersion(version string) ServiceManagerOption {
+	return func(sm *ServiceManager) error {
+		sm.apiVersion = version
+		return nil
+	}
+}
+
+// ServiceManagerWithHTTPClient configures the service manager to use the provided HTTP client for all REST calls
+func ServiceManagerWithHTTPClient(client *http.Client) ServiceManagerOption {
+	return func(sm *ServiceManager) error {
+		sm.httpClient = client
+		return nil
+	}
+}
+
+// ServiceManagerWithBatchConcurrency configures the maximum number of concurrent requests used when fanning out a
+// batch send such as `SendToUsers` or `SendToGroups`
+func ServiceManagerWithBatchConcurrency(concurrency int) ServiceManagerOption {
+	return func(sm *ServiceManager) error {
+		if concurrency < 1 {
+			return errors.New("batch concurrency must be at least 1")
+		}
+		sm.concurrency = concurrency
+		return nil
+	}
+}
+
+// ServiceManagerWithTokenLifetime configures how long the tokens the service manager signs with the access key in its
+// connection string are valid for, and the default lifetime of the client access tokens it issues
+func ServiceManagerWithTokenLifetime(lifetime time.Duration) ServiceManagerOption {
+	return func(sm *ServiceManager) error {
+		if lifetime <= 0 {
+			return errors.New("token lifetime must be positive")
+		}
+		sm.tokenLifetime = lifetime
+		return nil
+	}
+}
+
+// ServiceManagerWithNotBeforeSkew configures how far the not-before time of the tokens the service manager signs with
+// the access key in its connection string is backdated to tolerate clock skew between the host and the service
+func ServiceManagerWithNotBeforeSkew(skew time.Duration) ServiceManagerOption {
+	return func(sm *ServiceManager) error {
+		if skew < 0 {
+			return errors.New("not-before skew must not be negative")
+		}
+		sm.notBeforeSkew = skew
+		return nil
+	}
+}
+
+// NewServiceManager constructs a new service manager for a hub given a connection string and construction options
+func NewServiceManager(connStr string, hubName string, opts ...ServiceManagerOption) (*ServiceManager, error) {
+	parsed, err := ParseConnectionString(connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return newServiceManager(parsed, hubName, opts...)
+}
+
+func newServiceManager(parsed *ParsedConnString, hubName string, opts ...ServiceManagerOption) (*ServiceManager, error) {
+	sm := &ServiceManager{
+		hubName:       hubName,
+		apiVersion:    DefaultAPIVersion,
+		parsedConnStr: parsed,
+		httpClient:    newHTTPClient(),
+		concurrency:   DefaultBatchConcurrency,
+		clientTokens:  newTokenCache(),
+		tokenLifetime: DefaultTokenLifetime,
+	}
+
+	for _, opt := range opts {
+		if err := opt(sm); err != nil {
+			return sm, err
+		}
+	}
+
+	if sm.tokenProvider == nil {
+		provider, err := newTokenProvider(parsed,
+			AccessKeyWithTokenLifetime(sm.tokenLifetime),
+			AccessKeyWithNotBeforeSkew(sm.notBeforeSkew))
+		if err != nil {
+			return sm, err
+		}
+		sm.tokenProvider = provider
+	}
+	sm.tokenProvider = newCachingTokenProvider(sm.tokenProvider)
+
+	return sm, nil
+}
+
+// GetHub returns the name of the SignalR hub the service manager is targeting
+func (sm *ServiceManager) GetHub() string {
+	return sm.hubName
+}
+
+// Broadcast will send an `InvocationMessage` to all connections on the hub
+func (sm *ServiceManager) Broadcast(ctx context.Context, msg *InvocationMessage) error {
+	return sm.send(ctx, sm.hubPath(":send"), msg)
+}
+
+// SendToUser will send an `InvocationMessage` to all connections of a particular user
+func (sm *ServiceManager) SendToUser(ctx context.Context, msg *InvocationMessage, userID string) error {
+	return sm.send(ctx, sm.hubPath("users", userID, ":send"), msg)
+}
+
+// SendToGroup will send an `InvocationMessage` to all connections in a group
+func (sm *ServiceManager) SendToGroup(ctx context.Context, msg *InvocationMessage, groupName string) error {
+	return sm.send(ctx, sm.hubPath("groups", groupName, ":send"), msg)
+}
+
+// SendToUsers will send an `InvocationMessage` to each of the users. The management REST API does not offer a
+// multi-user send operation, so the sends are fanned out with bounded concurrency. Every user is attempted; if any of
+// the sends fail a `BatchSendError` is returned reporting the failure for each user.
+func (sm *ServiceManager) SendToUsers(ctx context.Context, msg *InvocationMessage, userIDs []string) error {
+	return fanOut(ctx, sm.concurrency, userIDs, func(ctx context.Context, userID string) error {
+		return sm.SendToUser(ctx, msg, userID)
+	})
+}
+
+// SendToGroups will send an `InvocationMessage` to each of the groups. The management REST API does not offer a
+// multi-group send operation, so the sends are fanned out with bounded concurrency. Every group is attempted; if any
+// of the sends fail a `BatchSendError` is returned reporting the failure for each group.
+func (sm *ServiceManager) SendToGroups(ctx context.Context, msg *InvocationMessage, groupNames []string) error {
+	return fanOut(ctx, sm.concurrency, groupNames, func(ctx context.Context, groupName string) error {
+		return sm.SendToGroup(ctx, msg, groupName)
+	})
+}
+
+// SendToConnection will send an `InvocationMessage` to a single connection
+func (sm *ServiceManager) SendToConnection(ctx context.Context, msg *InvocationMessage, connectionID string) error {
+	return sm.send(ctx, sm.hubPath("connections", connectionID, ":send"), msg)
+}
+
+// AddUserToGroup will add a userID to a SignalR group
+func (sm *ServiceManager) AddUserToGroup(ctx context.Context, groupName string, userID string) error {
+	_, _, err := sm.execute(ctx, http.MethodPut, sm.hubPath("groups", groupName, "users", userID), nil, nil)
+	return err
+}
+
+// RemoveUserFromGroup will remove a userID from a SignalR group
+func (sm *ServiceManager) RemoveUserFromGroup(ctx context.Context, groupName string, userID string) error {
+	_, _, err := sm.execute(ctx, http.MethodDelete, sm.hubPath("groups", groupName, "users", userID), nil, nil)
+	return err
+}
+
+// RemoveUserFromAllGroups will remove a user from all groups
+func (sm *ServiceManager) RemoveUserFromAllGroups(ctx context.Context, userID string) error {
+	_, _, err := sm.execute(ctx, http.MethodDelete, sm.hubPath("users", userID, "groups"), nil, nil)
+	return err
+}
+
+// UserExistsInGroup checks if a user is a member of a SignalR group
+func (sm *ServiceManager) UserExistsInGroup(ctx context.Context, groupName string, userID string) (bool, error) {
+	return sm.exists(ctx, sm.hubPath("users", userID, "groups", groupName))
+}
+
+// AddConnectionToGroup will add a connection to a SignalR group
+func (sm *ServiceManager) AddConnectionToGroup(ctx context.Context, groupName string, connectionID string) error {
+	_, _, err := sm.execute(ctx, http.MethodPut, sm.hubPath("groups", groupName, "connections", connectionID), nil, nil)
+	return err
+}
+
+// RemoveConnectionFromGroup will remove a connection from a SignalR group
+func (sm *ServiceManager) RemoveConnectionFromGroup(ctx context.Context, groupName string, connectionID string) error {
+	_, _, err := sm.execute(ctx, http.MethodDelete, sm.hubPath("groups", groupName, "connections", connectionID), nil, nil)
+	return err
+}
+
+// RemoveConnectionFromAllGroups will remove a connection from all groups
+func (sm *ServiceManager) RemoveConnectionFromAllGroups(ctx context.Context, connectionID string) error {
+	_, _, err := sm.execute(ctx, http.MethodDelete, sm.hubPath("connections", connectionID, "groups"), nil, nil)
+	return err
+}
+
+// CloseConnection will close a client connection, optionally providing a reason to the client
+func (sm *ServiceManager) CloseConnection(ctx context.Context, connectionID string, reason string) error {
+	query := url.Values{}
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+	_, _, err := sm.execute(ctx, http.MethodDelete, sm.hubPath("connections", connectionID), query, nil)
+	return err
+}
+
+// ConnectionExists checks if a connection is currently connected to the hub
+func (sm *ServiceManager) ConnectionExists(ctx context.Context, connectionID string) (bool, error) {
+	return sm.exists(ctx, sm.hubPath("connections", connectionID))
+}
+
+// UserExists checks if a user has any connections to the hub
+func (sm *ServiceManager) UserExists(ctx context.Context, userID string) (bool, error) {
+	return sm.exists(ctx, sm.hubPath("users", userID))
+}
+
+// GroupExists checks if a group has any connections
+func (sm *ServiceManager) GroupExists(ctx context.Context, groupName string) (bool, error) {
+	return sm.exists(ctx, sm.hubPath("groups", groupName))
+}
+
+// GenerateClientToken returns an access token a client can use to connect to the hub as the user. Tokens are signed
+// locally when authenticating with an access key, otherwise they are requested from the service. If expiresAfter is
+// zero, the lifetime from the context or the service manager's configured lifetime is used.
+func (sm *ServiceManager) GenerateClientToken(ctx context.Context, userID string, expiresAfter time.Duration) (string, error) {
+	audience := fmt.Sprintf("%s/client/?hub=%s", sm.parsedConnStr.Endpoint, strings.ToLower(sm.hubName))
+	token, err := sm.clientToken(ctx, clientTokenRequest{Audience: audience, UserID: userID, ExpiresAfter: expiresAfter})
+	if err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
+// clientToken returns a client access token for the audience identifying the user. Tokens are cached until they are
+// close to expiring.
+func (sm *ServiceManager) clientToken(ctx context.Context, req clientTokenRequest) (AccessToken, error) {
+	req = sm.withClientTokenLifetime(ctx, req)
+	claimsKey, err := req.Claims.cacheKey()
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	key := strings.Join([]string{req.Audience, req.UserID, req.ExpiresAfter.String(), claimsKey}, "\n")
+	return sm.clientTokens.get(ctx, key, func(ctx context.Context) (AccessToken, error) {
+		return sm.fetchClientToken(ctx, req)
+	})
+}
+
+// issueClientToken returns a new client access token for the audience identifying the user, bypassing the cache so
+// tokens handed to clients are valid for the whole lifetime requested
+func (sm *ServiceManager) issueClientToken(ctx context.Context, req clientTokenRequest) (AccessToken, error) {
+	return sm.fetchClientToken(ctx, sm.withClientTokenLifetime(ctx, req))
+}
+
+// withClientTokenLifetime defaults the lifetime of the request to the lifetime from the context or the service
+// manager's configured lifetime
+func (sm *ServiceManager) withClientTokenLifetime(ctx context.Context, req clientTokenRequest) clientTokenRequest {
+	if req.ExpiresAfter <= 0 {
+		req.ExpiresAfter = sm.tokenLifetime
+		if override, ok := tokenLifetimeFromContext(ctx); ok {
+			req.ExpiresAfter = override
+		}
+	}
+	return req
+}
+
+func (sm *ServiceManager) fetchClientToken(ctx context.Context, req clientTokenRequest) (AccessToken, error) {
+	if provider, ok := unwrapTokenProvider(sm.tokenProvider).(clientTokenProvider); ok {
+		return provider.GetClientToken(ctx, req)
+	}
+
+	if req.Claims.hasTokenClaims() {
+		return AccessToken{}, errors.New("roles and custom claims can only be added to client access tokens signed with an access key")
+	}
+
+	query := url.Values{}
+	if req.UserID != "" {
+		query.Set("userId", req.UserID)
+	}
+	query.Set("minutesToExpire", strconv.Itoa(int(req.ExpiresAfter/time.Minute)))
+
+	_, bodyBits, err := sm.execute(ctx, http.MethodPost, sm.hubPath(":generateToken"), query, nil)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	var tokenRes struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(bodyBits, &tokenRes); err != nil {
+		return AccessToken{}, err
+	}
+
+	return AccessToken{
+		Token:     tokenRes.Token,
+		ExpiresOn: time.Now().Add(req.ExpiresAfter),
+	}, nil
+}
+
+// CheckHealth returns an error if the SignalR service instance reports it is not healthy or cannot be reached
+func (sm *ServiceManager) CheckHealth(ctx context.Context) error {
+	_, _, err := sm.execute(ctx, http.MethodHead, "/api/health", nil, nil)
+	return err
+}
+
+func (sm *ServiceManager) send(ctx context.Context, path string, msg *InvocationMessage) error {
+	bits, err := json.Marshal(payloadMessage{
+		Target:    msg.Target,
+		Arguments: msg.Arguments,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, _, err = sm.execute(ctx, http.MethodPost, path, nil, bits)
+	return err
+}
+
+// fanOut calls send for each distinct target using at most concurrency concurrent calls and collects the failures
+func fanOut(ctx context.Context, concurrency int, targets []string, send func(context.Context, string) error) error {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		failures = make(map[string]error)
+		sem      = make(chan struct{}, concurrency)
+		seen     = make(map[string]bool, len(targets))
+	)
+
+schedule:
+	for i, target := range targets {
+		if seen[target] {
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			// stop scheduling and fail the targets which were never sent
+			mu.Lock()
+			for _, unsent := range targets[i:] {
+				seen[unsent] = true
+				failures[unsent] = ctx.Err()
+			}
+			mu.Unlock()
+			break schedule
+		}
+		seen[target] = true
+
+		wg.Add(1)
+		go func(target string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := send(ctx, target); err != nil {
+				mu.Lock()
+				failures[target] = err
+				mu.Unlock()
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return BatchSendError{
+			Attempted: len(seen),
+			Failures:  failures,
+		}
+	}
+	return nil
+}
+
+func (sm *ServiceManager) exists(ctx context.Context, path string) (bool, error) {
+	status, _, err := sm.execute(ctx, http.MethodHead, path, nil, nil)
+	if err != nil {
+		if sfe, ok := err.(SendFailureError); ok && sfe.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return status == http.StatusOK, nil
+}
+
+// execute sends a request to the management REST API and returns the status code and body. Any status code above 399
+// is returned as a `SendFailureError`.
+func (sm *ServiceManager) execute(ctx context.Context, method, path string, query url.Values, body []byte) (int, []byte, error) {
+	signedWith := sm.accessKey()
+	status, resBody, err := sm.do(ctx, method, path, query, body)
+	if sm.rejectAccessKey(signedWith, err) {
+		return sm.do(ctx, method, path, query, body)
+	}
+	return status, resBody, err
+}
+
+// do sends a single request to the management REST API
+func (sm *ServiceManager) do(ctx context.Context, method, path string, query url.Values, body []byte) (int, []byte, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", sm.apiVersion)
+
+	// the token is issued for the service endpoint even when requests are sent to a separate server endpoint
+	audience := sm.parsedConnStr.Endpoint.String() + path
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, sm.parsedConnStr.ServerEndpoint.String()+path+"?"+query.Encode(), reader)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	token, err := sm.tokenProvider.GetToken(ctx, audience)
+	if err != nil {
+		return 0, nil, tokenProviderError{err: err}
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := sm.httpClient.Do(req.WithContext(ctx))
+	defer closeRes(res)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	bodyBits, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+
+	if res.StatusCode > 399 {
+		return res.StatusCode, nil, SendFailureError{
+			StatusCode: res.StatusCode,
+			Body:       string(bodyBits),
+		}
+	}
+
+	return res.StatusCode, bodyBits, nil
+}
+
+// hubPath builds the escaped path to a hub resource; each segment is escaped so names containing '/' or '?' are
+// addressed correctly
+func (sm *ServiceManager) hubPath(segments ...string) string {
+	escaped := make([]string, 0, len(segments)+3)
+	escaped = append(escaped, "api", "hubs", url.PathEscape(strings.ToLower(sm.hubName)))
+	for _, segment := range segments {
+		escaped = append(escaped, url.PathEscape(segment))
+	}
+	return "/" + strings.Join(escaped, "/")
+}
@@ -0,0 +1,296 @@
+package signalr_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+)
+
+type (
+	recordedRequest struct {
+		Method     string
+		Path       string
+		APIVersion string
+		Query      map[string][]string
+		Auth       string
+		Body       string
+	}
+)
+
+func TestServiceManager_Operations(t *testing.T) {
+	msg, err := signalr.NewInvocationMessage("target", "arg1")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		run    func(ctx context.Context, sm *signalr.ServiceManager) error
+	}{
+		{
+			name:   "Broadcast",
+			method: http.MethodPost,
+			path:   "/api/hubs/hub1/:send",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.Broadcast(ctx, msg)
+			},
+		},
+		{
+			name:   "SendToUser",
+			method: http.MethodPost,
+			path:   "/api/hubs/hub1/users/user%2F1%3Fx/:send",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.SendToUser(ctx, msg, "user/1?x")
+			},
+		},
+		{
+			name:   "SendToGroup",
+			method: http.MethodPost,
+			path:   "/api/hubs/hub1/groups/group%2F1/:send",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.SendToGroup(ctx, msg, "group/1")
+			},
+		},
+		{
+			name:   "SendToConnection",
+			method: http.MethodPost,
+			path:   "/api/hubs/hub1/connections/conn1/:send",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.SendToConnection(ctx, msg, "conn1")
+			},
+		},
+		{
+			name:   "AddUserToGroup",
+			method: http.MethodPut,
+			path:   "/api/hubs/hub1/groups/group%3F1/users/user1",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.AddUserToGroup(ctx, "group?1", "user1")
+			},
+		},
+		{
+			name:   "RemoveUserFromGroup",
+			method: http.MethodDelete,
+			path:   "/api/hubs/hub1/groups/group1/users/user1",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.RemoveUserFromGroup(ctx, "group1", "user1")
+			},
+		},
+		{
+			name:   "RemoveUserFromAllGroups",
+			method: http.MethodDelete,
+			path:   "/api/hubs/hub1/users/user1/groups",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.RemoveUserFromAllGroups(ctx, "user1")
+			},
+		},
+		{
+			name:   "AddConnectionToGroup",
+			method: http.MethodPut,
+			path:   "/api/hubs/hub1/groups/group1/connections/conn1",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.AddConnectionToGroup(ctx, "group1", "conn1")
+			},
+		},
+		{
+			name:   "RemoveConnectionFromGroup",
+			method: http.MethodDelete,
+			path:   "/api/hubs/hub1/groups/group1/connections/conn1",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.RemoveConnectionFromGroup(ctx, "group1", "conn1")
+			},
+		},
+		{
+			name:   "RemoveConnectionFromAllGroups",
+			method: http.MethodDelete,
+			path:   "/api/hubs/hub1/connections/conn1/groups",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.RemoveConnectionFromAllGroups(ctx, "conn1")
+			},
+		},
+		{
+			name:   "CloseConnection",
+			method: http.MethodDelete,
+			path:   "/api/hubs/hub1/connections/conn1",
+			run: func(ctx context.Context, sm *signalr.ServiceManager) error {
+				return sm.CloseConnection(ctx, "conn1", "")
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			withServiceManager(t, http.StatusAccepted, func(ctx context.Context, sm *signalr.ServiceManager, requests <-chan recordedRequest) {
+				require.NoError(t, c.run(ctx, sm))
+				req := <-requests
+				assert.Equal(t, c.method, req.Method)
+				assert.Equal(t, c.path, req.Path)
+				assert.Equal(t, signalr.DefaultAPIVersion, req.APIVersion)
+				assert.True(t, strings.HasPrefix(req.Auth, "Bearer "))
+				if c.method == http.MethodPost {
+					assert.JSONEq(t, `{"target":"target","arguments":["arg1"]}`, req.Body)
+				}
+			})
+		})
+	}
+}
+
+func TestServiceManager_Exists(t *testing.T) {
+	for status, expected := range map[int]bool{http.StatusOK: true, http.StatusNotFound: false} {
+		withServiceManager(t, status, func(ctx context.Context, sm *signalr.ServiceManager, requests <-chan recordedRequest) {
+			exists, err := sm.UserExistsInGroup(ctx, "group1", "user1")
+			require.NoError(t, err)
+			assert.Equal(t, expected, exists)
+			req := <-requests
+			assert.Equal(t, http.MethodHead, req.Method)
+			assert.Equal(t, "/api/hubs/hub1/users/user1/groups/group1", req.Path)
+		})
+	}
+}
+
+func TestServiceManager_Failure(t *testing.T) {
+	withServiceManager(t, http.StatusForbidden, func(ctx context.Context, sm *signalr.ServiceManager, requests <-chan recordedRequest) {
+		_, err := sm.ConnectionExists(ctx, "conn1")
+		require.Error(t, err)
+		sfe, ok := err.(signalr.SendFailureError)
+		require.True(t, ok)
+		assert.Equal(t, http.StatusForbidden, sfe.StatusCode)
+	})
+}
+
+func TestServiceManager_WithAPIVersion(t *testing.T) {
+	withServiceManager(t, http.StatusAccepted, func(ctx context.Context, sm *signalr.ServiceManager, requests <-chan recordedRequest) {
+		require.NoError(t, sm.CloseConnection(ctx, "conn1", "bye"))
+		req := <-requests
+		assert.Equal(t, "2020-10-01", req.APIVersion)
+		assert.Equal(t, []string{"bye"}, req.Query["reason"])
+	}, signalr.ServiceManagerWithAPIVersion("2020-10-01"))
+}
+
+func withServiceManager(t *testing.T, status int, test func(ctx context.Context, sm *signalr.ServiceManager, requests <-chan recordedRequest), opts ...signalr.ServiceManagerOption) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	requests := make(chan recordedRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bits, _ := ioutil.ReadAll(r.Body)
+		requests <- recordedRequest{
+			Method:     r.Method,
+			Path:       r.URL.EscapedPath(),
+			APIVersion: r.URL.Query().Get("api-version"),
+			Query:      r.URL.Query(),
+			Auth:       r.Header.Get("Authorization"),
+			Body:       string(bits),
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sm, err := signalr.NewServiceManager("Endpoint="+srv.URL+";AccessKey=foo+bar+baz=;Version=1.0;", "hub1", opts...)
+	require.NoError(t, err)
+	test(ctx, sm, requests)
+}
+
+func TestServiceManager_SendToUsers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxSeen  int
+		sent     []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		sent = append(sent, r.URL.EscapedPath())
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if strings.Contains(r.URL.Path, "/users/bad") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	sm, err := signalr.NewServiceManager("Endpoint="+srv.URL+";AccessKey=foo+bar+baz=;Version=1.0;", "hub1", signalr.ServiceManagerWithBatchConcurrency(2))
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("target")
+	require.NoError(t, err)
+
+	users := []string{"user1", "user2", "bad1", "user3", "bad2", "user1"}
+	err = sm.SendToUsers(ctx, msg, users)
+	require.Error(t, err)
+	bse, ok := err.(signalr.BatchSendError)
+	require.True(t, ok)
+	assert.Equal(t, 5, bse.Attempted)
+	assert.Len(t, bse.Failures, 2)
+	assert.Contains(t, bse.Failures, "bad1")
+	assert.Contains(t, bse.Failures, "bad2")
+	assert.Len(t, sent, 5)
+	assert.True(t, maxSeen <= 2, "expected at most 2 concurrent requests, saw %d", maxSeen)
+
+	assert.NoError(t, sm.SendToGroups(ctx, msg, []string{"group1", "group2"}))
+}
+
+func TestServiceManager_SendToUsersCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var requests int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	sm, err := signalr.NewServiceManager("Endpoint="+srv.URL+";AccessKey=foo+bar+baz=;Version=1.0;", "hub1", signalr.ServiceManagerWithBatchConcurrency(1))
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("target")
+	require.NoError(t, err)
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	// the targets waiting for a send slot are never sent once the context is done
+	err = sm.SendToUsers(ctx, msg, []string{"user1", "user2", "user3", "user2"})
+	require.Error(t, err)
+	bse, ok := err.(signalr.BatchSendError)
+	require.True(t, ok)
+	assert.Equal(t, 3, bse.Attempted)
+	assert.Len(t, bse.Failures, 3)
+	assert.Equal(t, context.Canceled, bse.Failures["user2"])
+	assert.Equal(t, context.Canceled, bse.Failures["user3"])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
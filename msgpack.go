@@ -0,0 +1,353 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// The MessagePack hub protocol is only used by upstream requests, so rather than taking a dependency this file
+// implements the subset of MessagePack the protocol needs: nil, booleans, numbers, strings, binary, arrays and maps.
+
+const (
+	// maxMsgpackDepth limits how deeply arrays and maps may nest, so hostile input cannot exhaust the stack
+	maxMsgpackDepth = 100
+)
+
+var (
+	errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+	errMsgpackTooDeep   = fmt.Errorf("msgpack: arrays and maps nested more than %d deep", maxMsgpackDepth)
+)
+
+// splitMsgpackRecords splits a body into the records it contains; each record is prefixed with its length encoded as
+// a variable length integer
+func splitMsgpackRecords(bits []byte) ([][]byte, error) {
+	var records [][]byte
+	for len(bits) > 0 {
+		var size uint64
+		var i int
+		for shift := uint(0); ; shift += 7 {
+			if i >= len(bits) || i >= 5 {
+				return nil, errors.New("msgpack: invalid record length prefix")
+			}
+			b := bits[i]
+			i++
+			size |= uint64(b&0x7f) << shift
+			if b&0x80 == 0 {
+				break
+			}
+		}
+
+		bits = bits[i:]
+		if uint64(len(bits)) < size {
+			return nil, errMsgpackTruncated
+		}
+		records = append(records, bits[:size])
+		bits = bits[size:]
+	}
+	return records, nil
+}
+
+// appendMsgpackRecord appends a record prefixed with its length
+func appendMsgpackRecord(buf *bytes.Buffer, record []byte) {
+	size := len(record)
+	for size >= 0x80 {
+		buf.WriteByte(byte(size) | 0x80)
+		size >>= 7
+	}
+	buf.WriteByte(byte(size))
+	buf.Write(record)
+}
+
+// decodeMsgpack decodes a single value into nil, bool, int64, uint64, float64, string, []byte, []interface{} or
+// map[string]interface{}
+func decodeMsgpack(r *bytes.Reader) (interface{}, error) {
+	return decodeMsgpackValue(r, 0)
+}
+
+// decodeMsgpackValue decodes a value nested within depth arrays and maps
+func decodeMsgpackValue(r *bytes.Reader, depth int) (interface{}, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, errMsgpackTruncated
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return readMsgpackString(r, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return readMsgpackArray(r, int(b&0x0f), depth+1)
+	case b&0xf0 == 0x80:
+		return readMsgpackMap(r, int(b&0x0f), depth+1)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readMsgpackLength(r, b-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(r, n)
+	case 0xca:
+		bits, err := readMsgpackBytes(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(bits))), nil
+	case 0xcb:
+		bits, err := readMsgpackBytes(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(bits)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		bits, err := readMsgpackBytes(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return readUint(bits), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		bits, err := readMsgpackBytes(r, 1<<(b-0xd0))
+		if err != nil {
+			return nil, err
+		}
+		u := readUint(bits)
+		shift := 64 - 8*uint(len(bits))
+		return int64(u<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackLength(r, b-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, n)
+	case 0xdc, 0xdd:
+		n, err := readMsgpackLength(r, b-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, n, depth+1)
+	case 0xde, 0xdf:
+		n, err := readMsgpackLength(r, b-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, n, depth+1)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%x", b)
+}
+
+// readMsgpackLength reads a big endian length of 1, 2 or 4 bytes given by the size class 0, 1 or 2
+func readMsgpackLength(r *bytes.Reader, sizeClass byte) (int, error) {
+	bits, err := readMsgpackBytes(r, 1<<sizeClass)
+	if err != nil {
+		return 0, err
+	}
+
+	n := readUint(bits)
+	if n > uint64(r.Len()) {
+		return 0, errMsgpackTruncated
+	}
+	return int(n), nil
+}
+
+func readUint(bits []byte) uint64 {
+	var u uint64
+	for _, b := range bits {
+		u = u<<8 | uint64(b)
+	}
+	return u
+}
+
+func readMsgpackBytes(r *bytes.Reader, n int) ([]byte, error) {
+	if n > r.Len() {
+		return nil, errMsgpackTruncated
+	}
+
+	bits := make([]byte, n)
+	if _, err := io.ReadFull(r, bits); err != nil {
+		return nil, errMsgpackTruncated
+	}
+	return bits, nil
+}
+
+func readMsgpackString(r *bytes.Reader, n int) (string, error) {
+	bits, err := readMsgpackBytes(r, n)
+	return string(bits), err
+}
+
+func readMsgpackArray(r *bytes.Reader, n, depth int) ([]interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errMsgpackTooDeep
+	}
+	if n > r.Len() {
+		return nil, errMsgpackTruncated
+	}
+
+	values := make([]interface{}, n)
+	for i := range values {
+		value, err := decodeMsgpackValue(r, depth)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func readMsgpackMap(r *bytes.Reader, n, depth int) (map[string]interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errMsgpackTooDeep
+	}
+	if n > r.Len() {
+		return nil, errMsgpackTruncated
+	}
+
+	values := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := decodeMsgpackValue(r, depth)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeMsgpackValue(r, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		if str, ok := key.(string); ok {
+			values[str] = value
+		} else {
+			values[fmt.Sprint(key)] = value
+		}
+	}
+	return values, nil
+}
+
+// encodeMsgpack encodes nil, booleans, numbers, strings, []byte, json.Number, json.RawMessage and the values produced
+// by decoding JSON into an interface{}
+func encodeMsgpack(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int:
+		encodeMsgpackInt(buf, int64(v))
+	case int64:
+		encodeMsgpackInt(buf, v)
+	case uint64:
+		if v <= math.MaxInt64 {
+			encodeMsgpackInt(buf, int64(v))
+		} else {
+			buf.WriteByte(0xcf)
+			_ = binary.Write(buf, binary.BigEndian, v)
+		}
+	case float64:
+		buf.WriteByte(0xcb)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			encodeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return encodeMsgpack(buf, f)
+	case string:
+		encodeMsgpackHeader(buf, len(v), 0xa0, 0x1f, 0xd9)
+		buf.WriteString(v)
+	case []byte:
+		encodeMsgpackHeader(buf, len(v), 0, 0, 0xc4)
+		buf.Write(v)
+	case json.RawMessage:
+		decoded, err := decodeJSONValue(v)
+		if err != nil {
+			return err
+		}
+		return encodeMsgpack(buf, decoded)
+	case []interface{}:
+		encodeMsgpackHeader(buf, len(v), 0x90, 0x0f, 0xdc-1)
+		for _, item := range v {
+			if err := encodeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		encodeMsgpackHeader(buf, len(v), 0x80, 0x0f, 0xde-1)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			_ = encodeMsgpack(buf, key)
+			if err := encodeMsgpack(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", value)
+	}
+	return nil
+}
+
+func encodeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(i))
+	default:
+		buf.WriteByte(0xd3)
+		_ = binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// encodeMsgpackHeader writes the header of a string, binary, array or map. Values up to fixMax long use the fix
+// format; longer values use the 8 (strings and binary only), 16 and 32 bit formats which follow the base code.
+func encodeMsgpackHeader(buf *bytes.Buffer, n int, fixCode byte, fixMax int, base byte) {
+	switch {
+	case fixMax > 0 && n <= fixMax:
+		buf.WriteByte(fixCode | byte(n))
+	case n <= math.MaxUint8 && (base == 0xd9 || base == 0xc4):
+		buf.WriteByte(base)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(base + 1)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(base + 2)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// decodeJSONValue decodes JSON into an interface{}, keeping numbers exact
+func decodeJSONValue(bits []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(bits))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
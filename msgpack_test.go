@@ -0,0 +1,115 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgpack_RoundTrip(t *testing.T) {
+	values := []interface{}{
+		nil,
+		true,
+		false,
+		int64(5),
+		int64(-20),
+		int64(-5000),
+		int64(1 << 40),
+		1.5,
+		"short",
+		strings.Repeat("x", 300),
+		strings.Repeat("y", 70000),
+		[]byte{1, 2, 3},
+		[]interface{}{int64(1), "two", nil},
+		map[string]interface{}{"a": int64(1), "b": []interface{}{true}},
+	}
+
+	for _, value := range values {
+		var buf bytes.Buffer
+		require.NoError(t, encodeMsgpack(&buf, value))
+		decoded, err := decodeMsgpack(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, value, decoded)
+	}
+}
+
+func TestMsgpack_Depth(t *testing.T) {
+	// arrays nested to the limit decode, one level deeper fails rather than exhausting the stack
+	nested := append(bytes.Repeat([]byte{0x91}, maxMsgpackDepth), 0xc0)
+	_, err := decodeMsgpack(bytes.NewReader(nested))
+	require.NoError(t, err)
+
+	_, err = decodeMsgpack(bytes.NewReader(append([]byte{0x91}, nested...)))
+	assert.Equal(t, errMsgpackTooDeep, err)
+
+	hostile := append(bytes.Repeat([]byte{0x81, 0xc0}, 1<<20), 0xc0)
+	_, err = decodeMsgpack(bytes.NewReader(hostile))
+	assert.Equal(t, errMsgpackTooDeep, err)
+}
+
+func TestMsgpack_Records(t *testing.T) {
+	var buf bytes.Buffer
+	appendMsgpackRecord(&buf, []byte{0xc0})
+	appendMsgpackRecord(&buf, bytes.Repeat([]byte{0xc3}, 200))
+
+	records, err := splitMsgpackRecords(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, []byte{0xc0}, records[0])
+	assert.Len(t, records[1], 200)
+
+	_, err = splitMsgpackRecords([]byte{0x05, 0xc0})
+	assert.Error(t, err)
+}
+
+func TestDecodeUpstreamMessages_Msgpack(t *testing.T) {
+	var record bytes.Buffer
+	require.NoError(t, encodeMsgpack(&record, []interface{}{
+		int64(invocationMessageType),
+		map[string]interface{}{},
+		"42",
+		"Echo",
+		[]interface{}{"ab", int64(2), map[string]interface{}{"fieldString": "s"}},
+	}))
+
+	var body bytes.Buffer
+	appendMsgpackRecord(&body, record.Bytes())
+	msgs, err := decodeUpstreamMessages(msgpackContentType, body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "Echo", msgs[0].Target)
+	assert.Equal(t, "42", msgs[0].InvocationID)
+	assert.Equal(t, []json.RawMessage{
+		json.RawMessage(`"ab"`),
+		json.RawMessage(`2`),
+		json.RawMessage(`{"fieldString":"s"}`),
+	}, msgs[0].Arguments)
+}
+
+func TestCompletionMessage_Msgpack(t *testing.T) {
+	msg := &InvocationMessage{InvocationID: "42", Target: "Add"}
+	for _, tc := range []struct {
+		result    interface{}
+		hasResult bool
+		err       error
+		expected  []interface{}
+	}{
+		{result: 3, hasResult: true, expected: []interface{}{int64(3), map[string]interface{}{}, "42", int64(msgpackNonVoidResult), int64(3)}},
+		{expected: []interface{}{int64(3), map[string]interface{}{}, "42", int64(msgpackVoidResult)}},
+		{err: UpstreamError{Message: "nope"}, expected: []interface{}{int64(3), map[string]interface{}{}, "42", int64(msgpackErrorResult), "nope"}},
+	} {
+		record, err := encodeCompletion(msgpackContentType, msg, tc.result, tc.hasResult, tc.err)
+		require.NoError(t, err)
+
+		records, err := splitMsgpackRecords(record)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		decoded, err := decodeMsgpack(bytes.NewReader(records[0]))
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, decoded)
+	}
+}
@@ -0,0 +1,179 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type (
+	// UserIDFunc authenticates a negotiate request and returns the ID of the user the caller connects as. Return an
+	// empty user ID for anonymous connections or an error to reject the request.
+	UserIDFunc func(r *http.Request) (string, error)
+
+	negotiateHandler struct {
+		client *Client
+		userID UserIDFunc
+	}
+
+	// ClientAccess is what a SignalR client needs to connect to the hub: the URL of the hub on the service's client
+	// endpoint and an access token
+	ClientAccess struct {
+		URL         string
+		AccessToken string
+		ExpiresOn   time.Time
+	}
+
+	// ClientAccessOption provides a way to configure the access issued by `GenerateClientAccess`
+	ClientAccessOption func(*clientAccessOptions) error
+
+	clientAccessOptions struct {
+		lifetime time.Duration
+		claims   ClientClaims
+	}
+
+	// clientNegotiateResponse redirects SignalR clients to the service with an access token
+	clientNegotiateResponse struct {
+		URL         string `json:"url"`
+		AccessToken string `json:"accessToken"`
+	}
+)
+
+// ClientAccessWithLifetime configures how long the access token is valid for
+func ClientAccessWithLifetime(lifetime time.Duration) ClientAccessOption {
+	return func(o *clientAccessOptions) error {
+		if lifetime <= 0 {
+			return errors.New("token lifetime must be positive")
+		}
+		o.lifetime = lifetime
+		return nil
+	}
+}
+
+// ClientAccessWithRoles adds roles to the access token in addition to those configured on the client
+func ClientAccessWithRoles(roles ...string) ClientAccessOption {
+	return func(o *clientAccessOptions) error {
+		o.claims.Roles = append(o.claims.Roles, roles...)
+		return nil
+	}
+}
+
+// ClientAccessWithGroups adds groups the user is added to in addition to those configured on the client
+func ClientAccessWithGroups(groups ...string) ClientAccessOption {
+	return func(o *clientAccessOptions) error {
+		o.claims.Groups = append(o.claims.Groups, groups...)
+		return nil
+	}
+}
+
+// ClientAccessWithClaims adds custom claims to the access token, replacing claims of the same name configured on the
+// client
+func ClientAccessWithClaims(claims map[string]interface{}) ClientAccessOption {
+	return func(o *clientAccessOptions) error {
+		if o.claims.Claims == nil {
+			o.claims.Claims = make(map[string]interface{}, len(claims))
+		}
+		for name, value := range claims {
+			o.claims.Claims[name] = value
+		}
+		return nil
+	}
+}
+
+// GenerateClientAccess issues access to the hub for a SignalR client connecting as the user, such as a browser or
+// mobile client served by a gateway. The URL addresses the client endpoint of the connection string and the token
+// carries the claims configured on the client along with those of the options. Tokens are valid for the client's
+// configured token lifetime unless `ClientAccessWithLifetime` is provided, and a new token is issued on every call.
+// The service does not join groups from access tokens, so the user is added to the groups of the claims through the
+// REST API before access is issued; the user stays in the groups until removed, and anonymous access cannot join
+// groups.
+func (c *Client) GenerateClientAccess(ctx context.Context, userID string, opts ...ClientAccessOption) (*ClientAccess, error) {
+	options := &clientAccessOptions{}
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, err
+		}
+	}
+
+	claims, err := c.buildClaims(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := c.negotiateEndpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims = claims.merge(&options.claims)
+	if claims != nil && len(claims.Groups) > 0 {
+		if userID == "" {
+			return nil, errors.New("anonymous client access cannot join groups")
+		}
+		for _, group := range claims.Groups {
+			if err := c.managers[endpoint].AddUserToGroup(ctx, group, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	token, err := c.managers[endpoint].issueClientToken(ctx, clientTokenRequest{
+		Audience:     c.getWssAudience(endpoint),
+		UserID:       userID,
+		ExpiresAfter: options.lifetime,
+		Claims:       claims,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientAccess{
+		URL:         c.getHubURI(endpoint.parsedConnStr.ClientEndpoint),
+		AccessToken: token.Token,
+		ExpiresOn:   token.ExpiresOn,
+	}, nil
+}
+
+// NegotiateHandler returns an `http.Handler` serving the negotiate endpoint browser and mobile clients call before
+// connecting. The handler authenticates the request with the user ID callback, issues a client access token for the
+// user with the claims configured on the client, and redirects the caller to the service by responding with
+// `{"url": ..., "accessToken": ...}`, which SignalR clients follow without additional configuration. Mount the
+// handler at `{hub URL}/negotiate`, the path the clients request.
+func (c *Client) NegotiateHandler(userID UserIDFunc) (http.Handler, error) {
+	if userID == nil {
+		return nil, errors.New("user ID func must not be nil")
+	}
+
+	return &negotiateHandler{
+		client: c,
+		userID: userID,
+	}, nil
+}
+
+func (nh *negotiateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := nh.userID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	access, err := nh.client.GenerateClientAccess(r.Context(), userID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(clientNegotiateResponse{
+		URL:         access.URL,
+		AccessToken: access.AccessToken,
+	})
+}
@@ -0,0 +1,137 @@
+package signalr_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+)
+
+func TestClient_NegotiateHandler(t *testing.T) {
+	client, err := signalr.NewClient(
+		"Endpoint=https://foo.service.signalr.net;AccessKey=foo;ClientEndpoint=https://clients.contoso.com;",
+		"Hub1",
+		signalr.ClientWithClaims(signalr.ClientClaims{Roles: []string{"reader"}}))
+	require.NoError(t, err)
+
+	handler, err := client.NegotiateHandler(func(r *http.Request) (string, error) {
+		if user := r.Header.Get("X-User"); user != "" {
+			return user, nil
+		}
+		return "", errors.New("not signed in")
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/hub1/negotiate?negotiateVersion=1", nil)
+	req.Header.Set("X-User", "user1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var res struct {
+		URL         string `json:"url"`
+		AccessToken string `json:"accessToken"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, "https://clients.contoso.com/client/?hub=hub1", res.URL)
+
+	token, err := jwt.Parse(res.AccessToken, func(*jwt.Token) (interface{}, error) { return []byte("foo"), nil })
+	require.NoError(t, err)
+	claims := token.Claims.(jwt.MapClaims)
+	assert.Equal(t, "https://foo.service.signalr.net/client/?hub=hub1", claims["aud"])
+	assert.Equal(t, "user1", claims[signalr.NameIDClaim])
+	assert.Equal(t, []interface{}{"reader"}, claims[signalr.RoleClaim])
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hub1/negotiate", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/hub1/negotiate", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	_, err = client.NegotiateHandler(nil)
+	assert.Error(t, err)
+}
+
+func TestClient_GenerateClientAccess(t *testing.T) {
+	client, err := signalr.NewClient(
+		"Endpoint=https://foo.service.signalr.net;AccessKey=foo;ClientEndpoint=https://clients.contoso.com;",
+		"hub1",
+		signalr.ClientWithClaims(signalr.ClientClaims{Roles: []string{"reader"}, Claims: map[string]interface{}{"tenant": "contoso"}}))
+	require.NoError(t, err)
+
+	access, err := client.GenerateClientAccess(context.Background(), "user1",
+		signalr.ClientAccessWithLifetime(10*time.Minute),
+		signalr.ClientAccessWithRoles("writer"),
+		signalr.ClientAccessWithClaims(map[string]interface{}{"tenant": "fabrikam"}))
+	require.NoError(t, err)
+	assert.Equal(t, "https://clients.contoso.com/client/?hub=hub1", access.URL)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), access.ExpiresOn, 5*time.Second)
+
+	token, err := jwt.Parse(access.AccessToken, func(*jwt.Token) (interface{}, error) { return []byte("foo"), nil })
+	require.NoError(t, err)
+	claims := token.Claims.(jwt.MapClaims)
+	assert.Equal(t, "user1", claims[signalr.NameIDClaim])
+	assert.Equal(t, []interface{}{"reader", "writer"}, claims[signalr.RoleClaim])
+	assert.Equal(t, "fabrikam", claims["tenant"])
+	assert.InDelta(t, access.ExpiresOn.Unix(), claims["exp"], 1)
+
+	_, err = client.GenerateClientAccess(context.Background(), "user1", signalr.ClientAccessWithLifetime(0))
+	assert.Error(t, err)
+}
+
+func TestClient_GenerateClientAccessFreshTokens(t *testing.T) {
+	client, err := signalr.NewClient("Endpoint=https://foo.service.signalr.net;AccessKey=foo;", "hub1")
+	require.NoError(t, err)
+
+	first, err := client.GenerateClientAccess(context.Background(), "user1", signalr.ClientAccessWithLifetime(10*time.Minute))
+	require.NoError(t, err)
+
+	// a later caller asking for the same access still gets the whole lifetime it asked for
+	time.Sleep(250 * time.Millisecond)
+	issued := time.Now()
+	second, err := client.GenerateClientAccess(context.Background(), "user1", signalr.ClientAccessWithLifetime(10*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, second.ExpiresOn.After(first.ExpiresOn))
+	assert.WithinDuration(t, issued.Add(10*time.Minute), second.ExpiresOn, 100*time.Millisecond)
+}
+
+func TestClient_GenerateClientAccessJoinsGroups(t *testing.T) {
+	var joined []string
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		joined = append(joined, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer service.Close()
+
+	client, err := signalr.NewClient("Endpoint="+service.URL+";AccessKey=foo;", "hub1",
+		signalr.ClientWithClaims(signalr.ClientClaims{Groups: []string{"group1"}}))
+	require.NoError(t, err)
+
+	// the service does not join groups from tokens, so the user is added to them before access is issued
+	access, err := client.GenerateClientAccess(context.Background(), "user1", signalr.ClientAccessWithGroups("group2"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"PUT /api/hubs/hub1/groups/group1/users/user1",
+		"PUT /api/hubs/hub1/groups/group2/users/user1",
+	}, joined)
+
+	token, err := jwt.Parse(access.AccessToken, func(*jwt.Token) (interface{}, error) { return []byte("foo"), nil })
+	require.NoError(t, err)
+	assert.NotContains(t, token.Claims.(jwt.MapClaims), "signalr.groups")
+
+	_, err = client.GenerateClientAccess(context.Background(), "")
+	assert.Error(t, err)
+	assert.Len(t, joined, 2)
+}
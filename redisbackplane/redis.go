@@ -0,0 +1,321 @@
+// Package redisbackplane provides a signalr.Backplane over Redis, letting several hub servers behind a load balancer
+// share groups, users and presence.
+package redisbackplane
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devigned/signalr-go"
+)
+
+const (
+	// DefaultDialTimeout is the time allowed to connect to Redis
+	DefaultDialTimeout = 5 * time.Second
+)
+
+type (
+	// Backplane is a `signalr.Backplane` storing sets and publishing messages in Redis. Commands share a single
+	// connection, and each subscription uses a connection of its own.
+	Backplane struct {
+		addr        string
+		dialTimeout time.Duration
+
+		mu     sync.Mutex
+		conn   net.Conn
+		reader *bufio.Reader
+	}
+
+	// BackplaneOption provides configuration for the backplane
+	BackplaneOption func(*Backplane) error
+
+	// ErrorReply is an error returned by Redis in reply to a command
+	ErrorReply struct {
+		Message string
+	}
+)
+
+var _ signalr.Backplane = (*Backplane)(nil)
+
+// WithDialTimeout sets the time allowed to connect to Redis
+func WithDialTimeout(timeout time.Duration) BackplaneOption {
+	return func(b *Backplane) error {
+		if timeout <= 0 {
+			return errors.New("dial timeout must be greater than 0")
+		}
+		b.dialTimeout = timeout
+		return nil
+	}
+}
+
+// New creates a backplane for the Redis server at addr, given as host:port, and checks it is reachable
+func New(addr string, opts ...BackplaneOption) (*Backplane, error) {
+	b := &Backplane{
+		addr:        addr,
+		dialTimeout: DefaultDialTimeout,
+	}
+
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.dialTimeout)
+	defer cancel()
+	if _, err := b.do(ctx, "PING"); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close closes the command connection. Subscriptions remain until they are unsubscribed.
+func (b *Backplane) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
+
+// Publish sends the message to every subscriber of the channel
+func (b *Backplane) Publish(ctx context.Context, channel string, message []byte) error {
+	_, err := b.do(ctx, "PUBLISH", channel, string(message))
+	return err
+}
+
+// Subscribe calls deliver with each message published to the channel until unsubscribe is called
+func (b *Backplane) Subscribe(ctx context.Context, channel string, deliver func(message []byte)) (func(), error) {
+	conn, err := b.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	if err := writeCommand(ctx, conn, "SUBSCRIBE", channel); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if _, err := readReply(reader); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// the deadline set for the subscribe command must not end the subscription
+	_ = conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			reply, err := readReply(reader)
+			if err != nil {
+				return
+			}
+
+			// messages arrive as ["message", channel, payload]
+			items, ok := reply.([]interface{})
+			if !ok || len(items) != 3 {
+				continue
+			}
+
+			if kind, ok := items[0].([]byte); !ok || string(kind) != "message" {
+				continue
+			}
+
+			if payload, ok := items[2].([]byte); ok {
+				deliver(payload)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			_ = conn.Close()
+			<-done
+		})
+	}, nil
+}
+
+// SetAdd adds the members to the set
+func (b *Backplane) SetAdd(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	_, err := b.do(ctx, "SADD", append([]string{key}, members...)...)
+	return err
+}
+
+// SetRemove removes the members from the set
+func (b *Backplane) SetRemove(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	_, err := b.do(ctx, "SREM", append([]string{key}, members...)...)
+	return err
+}
+
+// SetMembers returns the members of the set
+func (b *Backplane) SetMembers(ctx context.Context, key string) ([]string, error) {
+	reply, err := b.do(ctx, "SMEMBERS", key)
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply to SMEMBERS: %v", reply)
+	}
+
+	members := make([]string, 0, len(items))
+	for _, item := range items {
+		if member, ok := item.([]byte); ok {
+			members = append(members, string(member))
+		}
+	}
+	return members, nil
+}
+
+// SetIsMember reports whether the member is in the set
+func (b *Backplane) SetIsMember(ctx context.Context, key, member string) (bool, error) {
+	reply, err := b.do(ctx, "SISMEMBER", key, member)
+	if err != nil {
+		return false, err
+	}
+
+	n, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("unexpected reply to SISMEMBER: %v", reply)
+	}
+	return n == 1, nil
+}
+
+// Delete removes the set
+func (b *Backplane) Delete(ctx context.Context, key string) error {
+	_, err := b.do(ctx, "DEL", key)
+	return err
+}
+
+// do sends a command over the command connection and returns the reply. The connection is dropped after a failure,
+// so the next command connects again.
+func (b *Backplane) do(ctx context.Context, cmd string, args ...string) (interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		conn, err := b.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b.conn = conn
+		b.reader = bufio.NewReader(conn)
+	}
+
+	reply, err := b.roundTrip(ctx, cmd, args...)
+	if err != nil {
+		if _, ok := err.(ErrorReply); !ok {
+			_ = b.conn.Close()
+			b.conn = nil
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (b *Backplane) roundTrip(ctx context.Context, cmd string, args ...string) (interface{}, error) {
+	if err := writeCommand(ctx, b.conn, cmd, args...); err != nil {
+		return nil, err
+	}
+	return readReply(b.reader)
+}
+
+func (b *Backplane) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: b.dialTimeout}
+	return dialer.DialContext(ctx, "tcp", b.addr)
+}
+
+func (e ErrorReply) Error() string {
+	return "redis: " + e.Message
+}
+
+// writeCommand sends the command as a RESP array of bulk strings, bounded by the deadline of the context
+func writeCommand(ctx context.Context, conn net.Conn, cmd string, args ...string) error {
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)+1), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range append([]string{cmd}, args...) {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	_, err := conn.Write(buf)
+	return err
+}
+
+// readReply reads a RESP reply: simple strings are returned as string, integers as int64, bulk strings as []byte,
+// arrays as []interface{} and errors as ErrorReply. Null bulk strings and arrays are returned as nil.
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply line: %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, ErrorReply{Message: line}
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, bulk); err != nil {
+			return nil, err
+		}
+		return bulk[:size], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
@@ -0,0 +1,97 @@
+package redisbackplane_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go/redisbackplane"
+	"github.com/devigned/signalr-go/signalrtest"
+)
+
+func newBackplane(t *testing.T) (*redisbackplane.Backplane, func()) {
+	rs, err := signalrtest.NewRedisServer()
+	require.NoError(t, err)
+
+	backplane, err := redisbackplane.New(rs.Addr)
+	if err != nil {
+		rs.Close()
+		require.NoError(t, err)
+	}
+
+	return backplane, func() {
+		_ = backplane.Close()
+		rs.Close()
+	}
+}
+
+func TestNew_Unreachable(t *testing.T) {
+	rs, err := signalrtest.NewRedisServer()
+	require.NoError(t, err)
+	addr := rs.Addr
+	rs.Close()
+
+	_, err = redisbackplane.New(addr, redisbackplane.WithDialTimeout(time.Second))
+	assert.Error(t, err)
+}
+
+func TestBackplane_Sets(t *testing.T) {
+	backplane, cleanup := newBackplane(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	members, err := backplane.SetMembers(ctx, "room")
+	require.NoError(t, err)
+	assert.Empty(t, members)
+
+	require.NoError(t, backplane.SetAdd(ctx, "room", "alice", "bob", "carol"))
+	require.NoError(t, backplane.SetRemove(ctx, "room", "bob"))
+	members, err = backplane.SetMembers(ctx, "room")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice", "carol"}, members)
+
+	isMember, err := backplane.SetIsMember(ctx, "room", "alice")
+	require.NoError(t, err)
+	assert.True(t, isMember)
+	isMember, err = backplane.SetIsMember(ctx, "room", "bob")
+	require.NoError(t, err)
+	assert.False(t, isMember)
+
+	require.NoError(t, backplane.Delete(ctx, "room"))
+	members, err = backplane.SetMembers(ctx, "room")
+	require.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func TestBackplane_PublishSubscribe(t *testing.T) {
+	backplane, cleanup := newBackplane(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := make(chan []byte, 10)
+	unsubscribe, err := backplane.Subscribe(ctx, "news", func(message []byte) {
+		received <- message
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, backplane.Publish(ctx, "sports", []byte("ignored")))
+	require.NoError(t, backplane.Publish(ctx, "news", []byte("hello\r\nworld")))
+	select {
+	case message := <-received:
+		assert.Equal(t, "hello\r\nworld", string(message))
+	case <-ctx.Done():
+		t.Fatal("no message received")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	require.NoError(t, backplane.Publish(ctx, "news", []byte("too late")))
+	assert.Empty(t, received)
+}
@@ -0,0 +1,218 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type (
+	// HandlerRegistry is a `Handler` which dispatches each invocation to the function registered for its target. Unlike
+	// the methods of a handler, registered functions are checked as they are registered, so an unusable function fails
+	// at startup rather than when a message arrives, and invocations with the wrong number of arguments fail rather than
+	// falling back to `Default`.
+	HandlerRegistry struct {
+		mu       sync.RWMutex
+		targets  map[string]*targetHandler
+		fallback Handler
+	}
+
+	// HandlerRegistryOption provides configuration for the handler registry
+	HandlerRegistryOption func(*HandlerRegistry) error
+
+	targetHandler struct {
+		numArgs int
+		call    func(ctx context.Context, args []json.RawMessage) (interface{}, bool, error)
+	}
+
+	// invoker is implemented by handlers which dispatch invocations themselves rather than by their methods
+	invoker interface {
+		invoke(ctx context.Context, msg *InvocationMessage) (interface{}, bool, error)
+	}
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// HandlerRegistryWithDefault configures the handler invocations of targets nothing is registered for are passed to.
+// Without it, such invocations fail with an `UnknownTargetError`.
+func HandlerRegistryWithDefault(handler Handler) HandlerRegistryOption {
+	return func(r *HandlerRegistry) error {
+		if handler == nil {
+			return errors.New("default handler must not be nil")
+		}
+		r.fallback = handler
+		return nil
+	}
+}
+
+// NewHandlerRegistry creates a handler registry with no targets registered
+func NewHandlerRegistry(opts ...HandlerRegistryOption) (*HandlerRegistry, error) {
+	r := &HandlerRegistry{
+		targets: make(map[string]*targetHandler),
+	}
+
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// On registers fn to handle invocations of the target. fn must be a func taking a `context.Context` followed by one
+// parameter per argument of the invocation, each of which is decoded from JSON. It may return nothing, an error, a
+// result, or a result and an error. A `HandlerRegistrationError` is returned if fn is not such a func or the target
+// is already registered.
+func (r *HandlerRegistry) On(target string, fn interface{}) error {
+	th, err := newTargetHandler(target, fn)
+	if err != nil {
+		return HandlerRegistrationError{Target: target, Reason: err.Error()}
+	}
+	return r.register(target, th)
+}
+
+// On1 registers fn to handle invocations of the target with a single argument of type T
+func On1[T any](r *HandlerRegistry, target string, fn func(ctx context.Context, arg T) error) error {
+	if fn == nil {
+		return HandlerRegistrationError{Target: target, Reason: "func must not be nil"}
+	}
+
+	return r.register(target, &targetHandler{
+		numArgs: 1,
+		call: func(ctx context.Context, args []json.RawMessage) (interface{}, bool, error) {
+			var arg T
+			if err := decodeArgument(target, args, 0, &arg); err != nil {
+				return nil, false, err
+			}
+			return nil, false, fn(ctx, arg)
+		},
+	})
+}
+
+// On2 registers fn to handle invocations of the target with two arguments of types A and B
+func On2[A, B any](r *HandlerRegistry, target string, fn func(ctx context.Context, a A, b B) error) error {
+	if fn == nil {
+		return HandlerRegistrationError{Target: target, Reason: "func must not be nil"}
+	}
+
+	return r.register(target, &targetHandler{
+		numArgs: 2,
+		call: func(ctx context.Context, args []json.RawMessage) (interface{}, bool, error) {
+			var a A
+			if err := decodeArgument(target, args, 0, &a); err != nil {
+				return nil, false, err
+			}
+			var b B
+			if err := decodeArgument(target, args, 1, &b); err != nil {
+				return nil, false, err
+			}
+			return nil, false, fn(ctx, a, b)
+		},
+	})
+}
+
+// Default dispatches the invocation to the function registered for the target
+func (r *HandlerRegistry) Default(ctx context.Context, target string, args []json.RawMessage) error {
+	_, _, err := r.invoke(ctx, &InvocationMessage{Target: target, Arguments: args})
+	return err
+}
+
+func (r *HandlerRegistry) invoke(ctx context.Context, msg *InvocationMessage) (interface{}, bool, error) {
+	r.mu.RLock()
+	th, ok := r.targets[msg.Target]
+	r.mu.RUnlock()
+
+	if !ok {
+		if r.fallback != nil {
+			return invoke(ctx, r.fallback, msg)
+		}
+		return nil, false, UnknownTargetError{Target: msg.Target}
+	}
+
+	if len(msg.Arguments) != th.numArgs {
+		return nil, false, InvocationArgumentsError{
+			Target: msg.Target,
+			Reason: fmt.Sprintf("expected %d arguments, but received %d", th.numArgs, len(msg.Arguments)),
+		}
+	}
+	return th.call(ctx, msg.Arguments)
+}
+
+func (r *HandlerRegistry) register(target string, th *targetHandler) error {
+	if target == "" {
+		return HandlerRegistrationError{Target: target, Reason: "target must not be empty"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.targets[target]; ok {
+		return HandlerRegistrationError{Target: target, Reason: "target is already registered"}
+	}
+	r.targets[target] = th
+	return nil
+}
+
+// newTargetHandler checks fn can handle invocations and builds a handler calling it by reflection
+func newTargetHandler(target string, fn interface{}) (*targetHandler, error) {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func {
+		return nil, fmt.Errorf("expected a func, but got %T", fn)
+	}
+	if value.IsNil() {
+		return nil, errors.New("func must not be nil")
+	}
+
+	ft := value.Type()
+	if ft.IsVariadic() {
+		return nil, errors.New("func must not be variadic")
+	}
+	if ft.NumIn() == 0 || ft.In(0) != contextType {
+		return nil, errors.New("the first parameter must be a context.Context")
+	}
+
+	decoders := make([]argumentDecoder, ft.NumIn()-1)
+	for i := range decoders {
+		argType := ft.In(i + 1)
+		switch argType.Kind() {
+		case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+			return nil, fmt.Errorf("parameter %d of type %s cannot be decoded from JSON", i+1, argType)
+		}
+		decoders[i] = newArgumentDecoder(argType)
+	}
+
+	switch {
+	case ft.NumOut() <= 1:
+	case ft.NumOut() == 2 && ft.Out(1) == errorType:
+	default:
+		return nil, errors.New("func must return nothing, an error, a result, or a result and an error")
+	}
+
+	return &targetHandler{
+		numArgs: len(decoders),
+		call: func(ctx context.Context, args []json.RawMessage) (interface{}, bool, error) {
+			in := make([]reflect.Value, len(args)+1)
+			in[0] = reflect.ValueOf(&ctx).Elem()
+			for i, decode := range decoders {
+				arg, err := decode(args[i])
+				if err != nil {
+					return nil, false, InvocationArgumentsError{Target: target, Reason: fmt.Sprintf("argument %d: %v", i, err)}
+				}
+				in[i+1] = arg
+			}
+			return methodResult(target, value.Call(in))
+		},
+	}, nil
+}
+
+// decodeArgument decodes the argument at index i of the invocation into v
+func decodeArgument(target string, args []json.RawMessage, i int, v interface{}) error {
+	if err := json.Unmarshal(args[i], v); err != nil {
+		return InvocationArgumentsError{Target: target, Reason: fmt.Sprintf("argument %d: %v", i, err)}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package signalr_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+
+	"github.com/devigned/signalr-go"
+)
+
+func rawArgs(t *testing.T, args ...interface{}) []json.RawMessage {
+	raw := make([]json.RawMessage, len(args))
+	for i, arg := range args {
+		bits, err := json.Marshal(arg)
+		require.NoError(t, err)
+		raw[i] = bits
+	}
+	return raw
+}
+
+func TestHandlerRegistry_OnValidation(t *testing.T) {
+	registry, err := signalr.NewHandlerRegistry()
+	require.NoError(t, err)
+
+	cases := map[string]interface{}{
+		"NotAFunc":       "hello",
+		"NilFunc":        (func(context.Context))(nil),
+		"NoContext":      func(name string) {},
+		"Variadic":       func(ctx context.Context, names ...string) {},
+		"ChanArgument":   func(ctx context.Context, c chan int) {},
+		"ThreeReturns":   func(ctx context.Context) (int, int, error) { return 0, 0, nil },
+		"SecondNotError": func(ctx context.Context) (int, int) { return 0, 0 },
+	}
+	for target, fn := range cases {
+		t.Run(target, func(t *testing.T) {
+			err := registry.On(target, fn)
+			assert.IsType(t, signalr.HandlerRegistrationError{}, err)
+		})
+	}
+
+	assert.IsType(t, signalr.HandlerRegistrationError{}, registry.On("", func(ctx context.Context) {}))
+	require.NoError(t, registry.On("Ping", func(ctx context.Context) {}))
+	assert.IsType(t, signalr.HandlerRegistrationError{}, registry.On("Ping", func(ctx context.Context) {}))
+	assert.IsType(t, signalr.HandlerRegistrationError{}, signalr.On1(registry, "Ping", func(ctx context.Context, s string) error {
+		return nil
+	}))
+	assert.IsType(t, signalr.HandlerRegistrationError{}, signalr.On1[string](registry, "Nil", nil))
+}
+
+func TestHandlerRegistry_Dispatch(t *testing.T) {
+	registry, err := signalr.NewHandlerRegistry()
+	require.NoError(t, err)
+
+	var received []string
+	require.NoError(t, registry.On("Echo", func(ctx context.Context, message string, times int) {
+		for i := 0; i < times; i++ {
+			received = append(received, message)
+		}
+	}))
+	require.NoError(t, signalr.On1(registry, "Greet", func(ctx context.Context, obj ComplexObject) error {
+		received = append(received, "hello "+obj.FieldString)
+		return nil
+	}))
+	require.NoError(t, signalr.On2(registry, "Join", func(ctx context.Context, user string, groups []string) error {
+		for _, group := range groups {
+			received = append(received, user+"@"+group)
+		}
+		return nil
+	}))
+
+	ctx := context.Background()
+	require.NoError(t, registry.Default(ctx, "Echo", rawArgs(t, "hi", 2)))
+	require.NoError(t, registry.Default(ctx, "Greet", rawArgs(t, ComplexObject{FieldString: "world"})))
+	require.NoError(t, registry.Default(ctx, "Join", rawArgs(t, "bob", []string{"a", "b"})))
+	assert.Equal(t, []string{"hi", "hi", "hello world", "bob@a", "bob@b"}, received)
+
+	assert.Equal(t, signalr.UnknownTargetError{Target: "Missing"}, registry.Default(ctx, "Missing", nil))
+	assert.IsType(t, signalr.InvocationArgumentsError{}, registry.Default(ctx, "Echo", rawArgs(t, "hi")))
+	assert.IsType(t, signalr.InvocationArgumentsError{}, registry.Default(ctx, "Echo", rawArgs(t, "hi", "twice")))
+	assert.IsType(t, signalr.InvocationArgumentsError{}, registry.Default(ctx, "Join", rawArgs(t, "bob", "a")))
+}
+
+func TestHandlerRegistry_Default(t *testing.T) {
+	var defaults []string
+	registry, err := signalr.NewHandlerRegistry(signalr.HandlerRegistryWithDefault(signalr.HandlerFunc(
+		func(ctx context.Context, target string, args []json.RawMessage) error {
+			defaults = append(defaults, target)
+			return nil
+		})))
+	require.NoError(t, err)
+
+	require.NoError(t, registry.Default(context.Background(), "Missing", nil))
+	assert.Equal(t, []string{"Missing"}, defaults)
+}
+
+func TestHandlerRegistry_HubServerResults(t *testing.T) {
+	registry, err := signalr.NewHandlerRegistry()
+	require.NoError(t, err)
+	require.NoError(t, registry.On("Add", func(ctx context.Context, a, b int) (int, error) {
+		return a + b, nil
+	}))
+
+	server, err := signalr.NewHubServer(registry)
+	require.NoError(t, err)
+	defer server.Close()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn := dialHub(ctx, t, ts.URL)
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	writeHub(ctx, t, conn, map[string]interface{}{"protocol": "json", "version": 1})
+	assert.Equal(t, []map[string]interface{}{{}}, readHub(ctx, t, conn))
+
+	writeHub(ctx, t, conn, map[string]interface{}{"type": 1, "invocationId": "1", "target": "Add", "arguments": []int{1, 2}})
+	assert.Equal(t, []map[string]interface{}{{"type": 3.0, "invocationId": "1", "result": 3.0}}, readHub(ctx, t, conn))
+
+	writeHub(ctx, t, conn, map[string]interface{}{"type": 1, "invocationId": "2", "target": "Subtract", "arguments": []int{1, 2}})
+	assert.Equal(t, []map[string]interface{}{{"type": 3.0, "invocationId": "2", "error": "An unexpected error occurred invoking 'Subtract' on the server."}}, readHub(ctx, t, conn))
+}
@@ -0,0 +1,132 @@
+package signalr
+
+import (
+	"errors"
+	"net/http"
+)
+
+// UpdateConnectionString replaces the access key of the endpoint the connection string addresses without rebuilding
+// the client. New tokens, including those used to reconnect `Listen`, are signed with the new key; the previous key
+// is kept as the secondary key and used if the service rejects the new key while it is rolled out.
+func (c *Client) UpdateConnectionString(connStr string) error {
+	parsed, err := ParseConnectionString(connStr)
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range c.endpoints {
+		if endpoint.parsedConnStr.Endpoint.String() == parsed.Endpoint.String() {
+			return c.managers[endpoint].rotateAccessKey(parsed)
+		}
+	}
+	return errors.New("the connection string does not address any of the client's endpoints")
+}
+
+// UpdateConnectionString replaces the access key of the service manager with the access key in the connection string,
+// which must address the same endpoint. The previous key is kept as the secondary key and used if the service rejects
+// the new key while it is rolled out.
+func (sm *ServiceManager) UpdateConnectionString(connStr string) error {
+	parsed, err := ParseConnectionString(connStr)
+	if err != nil {
+		return err
+	}
+
+	if sm.parsedConnStr.Endpoint.String() != parsed.Endpoint.String() {
+		return errors.New("the connection string must address the endpoint of the service manager")
+	}
+	return sm.rotateAccessKey(parsed)
+}
+
+// UpdateAccessKeys replaces the primary and secondary access keys the service manager signs tokens with. Tokens are
+// signed with the primary key; if the service rejects them the secondary key is used instead. Pass an empty secondary
+// key to sign only with the primary key.
+func (sm *ServiceManager) UpdateAccessKeys(primary, secondary string) error {
+	provider, ok := unwrapTokenProvider(sm.tokenProvider).(*accessKeyTokenProvider)
+	if !ok {
+		return errors.New("the service manager does not authenticate with an access key")
+	}
+
+	if primary == "" {
+		return errors.New("the primary access key must not be empty")
+	}
+
+	provider.setKeys(primary, secondary)
+	sm.clearTokens()
+	return nil
+}
+
+func (sm *ServiceManager) rotateAccessKey(parsed *ParsedConnString) error {
+	if parsed.AuthType == AuthTypeAAD {
+		return errors.New("only access keys can be rotated; create a new client to change the authentication type")
+	}
+
+	provider, ok := unwrapTokenProvider(sm.tokenProvider).(*accessKeyTokenProvider)
+	if !ok {
+		return errors.New("the service manager does not authenticate with an access key")
+	}
+
+	previous := provider.primaryKey()
+	if previous == parsed.Key {
+		return nil
+	}
+	return sm.UpdateAccessKeys(parsed.Key, previous)
+}
+
+// accessKey returns the key tokens are currently signed with, or an empty string if the service manager does not
+// authenticate with an access key
+func (sm *ServiceManager) accessKey() string {
+	if provider, ok := unwrapTokenProvider(sm.tokenProvider).(*accessKeyTokenProvider); ok {
+		return provider.primaryKey()
+	}
+	return ""
+}
+
+// rejectAccessKey switches to the secondary access key after the service rejected a token signed with the key.
+// It returns true if the request should be retried with the secondary key.
+func (sm *ServiceManager) rejectAccessKey(signedWith string, err error) bool {
+	if sfe, ok := err.(SendFailureError); signedWith == "" || !ok || sfe.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	provider, ok := unwrapTokenProvider(sm.tokenProvider).(*accessKeyTokenProvider)
+	if !ok || !provider.swapKeys(signedWith) {
+		return false
+	}
+
+	sm.clearTokens()
+	return true
+}
+
+// clearTokens drops the cached tokens so new tokens are signed with the current access key
+func (sm *ServiceManager) clearTokens() {
+	if caching, ok := sm.tokenProvider.(*cachingTokenProvider); ok {
+		caching.cache.clear()
+	}
+	sm.clientTokens.clear()
+}
+
+func (p *accessKeyTokenProvider) primaryKey() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.key
+}
+
+func (p *accessKeyTokenProvider) setKeys(primary, secondary string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = primary
+	p.secondaryKey = secondary
+}
+
+// swapKeys makes the secondary key the primary key if the rejected key is still the primary key, so concurrent
+// rejections of the same key only swap once
+func (p *accessKeyTokenProvider) swapKeys(rejected string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.key != rejected || p.secondaryKey == "" {
+		return false
+	}
+
+	p.key, p.secondaryKey = p.secondaryKey, p.key
+	return true
+}
@@ -0,0 +1,100 @@
+package signalr_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+)
+
+type (
+	keyedService struct {
+		*httptest.Server
+		mu       sync.Mutex
+		accepted []string
+		signed   []string
+	}
+)
+
+func newKeyedService(accepted ...string) *keyedService {
+	ks := &keyedService{accepted: accepted}
+	ks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		for _, key := range ks.accepted {
+			if _, err := jwt.Parse(raw, func(*jwt.Token) (interface{}, error) { return []byte(key), nil }); err == nil {
+				ks.signed = append(ks.signed, key)
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+		}
+		ks.signed = append(ks.signed, "")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	return ks
+}
+
+func (ks *keyedService) accept(keys ...string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.accepted = keys
+}
+
+func (ks *keyedService) signedWith() []string {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return append([]string(nil), ks.signed...)
+}
+
+func TestClient_UpdateConnectionString(t *testing.T) {
+	ctx := context.Background()
+	service := newKeyedService("old")
+	defer service.Close()
+
+	client, err := signalr.NewClient("Endpoint="+service.URL+";AccessKey=old;Version=1.0;", "hub1")
+	require.NoError(t, err)
+	msg, err := signalr.NewInvocationMessage("echo", "hi")
+	require.NoError(t, err)
+
+	require.NoError(t, client.BroadcastAll(ctx, msg))
+
+	// the service accepts both keys while the new key is rolled out
+	service.accept("old", "new")
+	require.NoError(t, client.UpdateConnectionString("Endpoint="+service.URL+";AccessKey=new;Version=1.0;"))
+	require.NoError(t, client.BroadcastAll(ctx, msg))
+	assert.Equal(t, []string{"old", "new"}, service.signedWith())
+
+	assert.Error(t, client.UpdateConnectionString("Endpoint=https://other.service.signalr.net;AccessKey=new;"))
+}
+
+func TestServiceManager_FallsBackToSecondaryKey(t *testing.T) {
+	ctx := context.Background()
+	service := newKeyedService("old")
+	defer service.Close()
+
+	sm, err := signalr.NewServiceManager("Endpoint="+service.URL+";AccessKey=old;Version=1.0;", "hub1")
+	require.NoError(t, err)
+	msg, err := signalr.NewInvocationMessage("echo", "hi")
+	require.NoError(t, err)
+
+	// the new key has not reached the service yet, so the old key is used until it does
+	require.NoError(t, sm.UpdateConnectionString("Endpoint="+service.URL+";AccessKey=new;Version=1.0;"))
+	require.NoError(t, sm.Broadcast(ctx, msg))
+	require.NoError(t, sm.Broadcast(ctx, msg))
+	assert.Equal(t, []string{"", "old", "old"}, service.signedWith())
+
+	// without a secondary key the rejection is returned
+	require.NoError(t, sm.UpdateAccessKeys("other", ""))
+	err = sm.Broadcast(ctx, msg)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, err.(signalr.SendFailureError).StatusCode)
+}
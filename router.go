@@ -0,0 +1,85 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+)
+
+type (
+	// EndpointRouter chooses which of a client's endpoints each operation is sent to. Each hook receives every endpoint
+	// configured on the client along with its current health. If a chosen endpoint fails with an error indicating it is
+	// unhealthy, the client fails over to the healthy secondary endpoints which were not chosen.
+	EndpointRouter interface {
+		// GetNegotiateEndpoint returns the endpoint `Listen` should connect to, which must be one of the endpoints
+		GetNegotiateEndpoint(ctx context.Context, endpoints []*ServiceEndpoint) *ServiceEndpoint
+		// GetEndpointsForBroadcast returns the endpoints a broadcast to the whole hub is sent to
+		GetEndpointsForBroadcast(ctx context.Context, endpoints []*ServiceEndpoint) []*ServiceEndpoint
+		// GetEndpointsForUser returns the endpoints messages and group membership changes for a user are sent to
+		GetEndpointsForUser(ctx context.Context, userID string, endpoints []*ServiceEndpoint) []*ServiceEndpoint
+		// GetEndpointsForGroup returns the endpoints messages for a group are sent to
+		GetEndpointsForGroup(ctx context.Context, groupName string, endpoints []*ServiceEndpoint) []*ServiceEndpoint
+	}
+
+	// DefaultEndpointRouter sends every operation to the healthy primary endpoints. If no primary is healthy, the
+	// healthy secondaries are used, and if nothing is known to be healthy every endpoint is tried.
+	DefaultEndpointRouter struct{}
+)
+
+// ClientWithEndpointRouter configures the client to use a custom `EndpointRouter` to choose the endpoints each
+// operation is sent to
+func ClientWithEndpointRouter(router EndpointRouter) ClientOption {
+	return func(client *Client) error {
+		if router == nil {
+			return errors.New("endpoint router must not be nil")
+		}
+		client.router = router
+		return nil
+	}
+}
+
+// GetNegotiateEndpoint returns the first healthy primary endpoint, falling back to the first healthy secondary
+func (DefaultEndpointRouter) GetNegotiateEndpoint(_ context.Context, endpoints []*ServiceEndpoint) *ServiceEndpoint {
+	return preferredEndpoints(endpoints)[0]
+}
+
+// GetEndpointsForBroadcast returns the healthy primary endpoints, falling back to the healthy secondaries
+func (DefaultEndpointRouter) GetEndpointsForBroadcast(_ context.Context, endpoints []*ServiceEndpoint) []*ServiceEndpoint {
+	return preferredEndpoints(endpoints)
+}
+
+// GetEndpointsForUser returns the healthy primary endpoints, falling back to the healthy secondaries
+func (DefaultEndpointRouter) GetEndpointsForUser(_ context.Context, _ string, endpoints []*ServiceEndpoint) []*ServiceEndpoint {
+	return preferredEndpoints(endpoints)
+}
+
+// GetEndpointsForGroup returns the healthy primary endpoints, falling back to the healthy secondaries
+func (DefaultEndpointRouter) GetEndpointsForGroup(_ context.Context, _ string, endpoints []*ServiceEndpoint) []*ServiceEndpoint {
+	return preferredEndpoints(endpoints)
+}
+
+// preferredEndpoints returns the healthy primaries, else the healthy secondaries, else all of the endpoints
+func preferredEndpoints(endpoints []*ServiceEndpoint) []*ServiceEndpoint {
+	primaries := filterEndpoints(endpoints, EndpointTypePrimary)
+	if len(primaries) > 0 {
+		return primaries
+	}
+
+	secondaries := filterEndpoints(endpoints, EndpointTypeSecondary)
+	if len(secondaries) > 0 {
+		return secondaries
+	}
+
+	// nothing is known to be healthy, so try everything rather than failing without a call
+	return endpoints
+}
+
+// filterEndpoints returns the healthy endpoints of the given type
+func filterEndpoints(endpoints []*ServiceEndpoint, endpointType EndpointType) []*ServiceEndpoint {
+	var filtered []*ServiceEndpoint
+	for _, endpoint := range endpoints {
+		if endpoint.IsHealthy() && endpoint.GetType() == endpointType {
+			filtered = append(filtered, endpoint)
+		}
+	}
+	return filtered
+}
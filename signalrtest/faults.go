@@ -0,0 +1,184 @@
+package signalrtest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// faults are the failures scripted to happen to upcoming connections and requests; s.mu guards them
+	faults struct {
+		handshakeErrors []string
+		dropAfter       []int
+		corruptFrames   int
+		throttled       int
+		retryAfter      time.Duration
+		pingDelay       time.Duration
+	}
+
+	// closeMessage is sent to clients before the emulator closes their connection
+	closeMessage struct {
+		Type           int    `json:"type"`
+		Error          string `json:"error,omitempty"`
+		AllowReconnect bool   `json:"allowReconnect,omitempty"`
+	}
+
+	// hijackRecorder keeps hold of the network connection a WebSocket is accepted on so it can be dropped without a
+	// closing handshake
+	hijackRecorder struct {
+		http.ResponseWriter
+		conn net.Conn
+	}
+)
+
+const (
+	// corruptFrame is a truncated JSON record clients fail to parse
+	corruptFrame = `{"type":1,"target":"`
+)
+
+// FailHandshakes makes the hub protocol handshake of the next n connections fail with the error message
+func (s *Server) FailHandshakes(n int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.faults.handshakeErrors = append(s.faults.handshakeErrors, message)
+	}
+}
+
+// DropAfter drops the next connection without a close message once n invocations have been sent to it. A
+// connection dropped after 0 invocations is dropped as soon as its handshake completes.
+func (s *Server) DropAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults.dropAfter = append(s.faults.dropAfter, n)
+}
+
+// CorruptFrames replaces the next n invocations sent to any connection with a frame clients cannot parse
+func (s *Server) CorruptFrames(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults.corruptFrames += n
+}
+
+// Throttle responds to the next n negotiate and REST API requests with 429 Too Many Requests and a Retry-After
+// header of retryAfter, rounded up to whole seconds
+func (s *Server) Throttle(n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults.throttled += n
+	s.faults.retryAfter = retryAfter
+}
+
+// DelayPings delays each ping sent to clients by d beyond the ping interval. Zero restores the ping interval.
+func (s *Server) DelayPings(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults.pingDelay = d
+}
+
+// ClearFaults cancels all scripted faults which have not happened yet
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = faults{}
+}
+
+// CloseConnections sends a close message with the reason to every connection to the hub and disconnects them. If
+// allowReconnect is true, clients are told they may reconnect.
+func (s *Server) CloseConnections(hubName, reason string, allowReconnect bool) {
+	s.mu.Lock()
+	var conns []*connection
+	if h, ok := s.hubs[strings.ToLower(hubName)]; ok {
+		for _, c := range h.connections {
+			conns = append(conns, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		s.close(context.Background(), c, closeMessage{
+			Type:           closeMessageType,
+			Error:          reason,
+			AllowReconnect: allowReconnect,
+		})
+	}
+}
+
+// throttle responds 429 Too Many Requests if the request is throttled, returning true if it was
+func (s *Server) throttle(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	if s.faults.throttled == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	s.faults.throttled--
+	retryAfter := s.faults.retryAfter
+	s.mu.Unlock()
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return true
+}
+
+// handshakeError returns the error the next handshake should fail with, if any
+func (s *Server) handshakeError() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.faults.handshakeErrors) == 0 {
+		return ""
+	}
+	msg := s.faults.handshakeErrors[0]
+	s.faults.handshakeErrors = s.faults.handshakeErrors[1:]
+	return msg
+}
+
+// armDropLocked assigns the next scripted drop to the connection; s.mu must be held
+func (s *Server) armDropLocked(c *connection) {
+	c.dropAfter = -1
+	if len(s.faults.dropAfter) > 0 {
+		c.dropAfter = s.faults.dropAfter[0]
+		s.faults.dropAfter = s.faults.dropAfter[1:]
+	}
+}
+
+// corrupt reports whether the next invocation should be corrupted
+func (s *Server) corrupt() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.faults.corruptFrames == 0 {
+		return false
+	}
+	s.faults.corruptFrames--
+	return true
+}
+
+// nextPing returns how long to wait before the next ping
+func (s *Server) nextPing() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pingInterval + s.faults.pingDelay
+}
+
+// drop closes the network connection without a WebSocket closing handshake
+func (s *Server) drop(c *connection) {
+	s.remove(c)
+	_ = c.netConn.Close()
+}
+
+func (hr *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := hr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+
+	conn, brw, err := hj.Hijack()
+	hr.conn = conn
+	return conn, brw, err
+}
@@ -0,0 +1,224 @@
+package signalrtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+	"github.com/devigned/signalr-go/signalrtest"
+)
+
+func broadcast(ctx context.Context, t *testing.T, client *signalr.Client, target string) {
+	msg, err := signalr.NewInvocationMessage(target)
+	require.NoError(t, err)
+	require.NoError(t, client.BroadcastAll(ctx, msg))
+}
+
+func receive(ctx context.Context, t *testing.T, targets <-chan string) string {
+	select {
+	case target := <-targets:
+		return target
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+		return ""
+	}
+}
+
+func stopped(ctx context.Context, t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
+
+// reconnected waits until the hub has a single connection other than the previous one
+func reconnected(ctx context.Context, t *testing.T, emulator *signalrtest.Server, hubName, previous string) {
+	for {
+		if conns := emulator.Connections(hubName); len(conns) == 1 && conns[0].ID != previous {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestServer_FailHandshakes(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+	emulator.FailHandshakes(1, "boom")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	err = client.Listen(ctx, signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+		return nil
+	}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "boom")
+}
+
+func TestServer_DropAfter(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+	emulator.DropAfter(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	listenCtx, stop := context.WithCancel(ctx)
+	targets, done := listen(listenCtx, t, client)
+	first := emulator.Connections("chat")[0].ID
+
+	broadcast(ctx, t, client, "first")
+	assert.Equal(t, "first", receive(ctx, t, targets))
+
+	reconnected(ctx, t, emulator, "chat", first)
+	broadcast(ctx, t, client, "second")
+	assert.Equal(t, "second", receive(ctx, t, targets))
+
+	stop()
+	stopped(ctx, t, done)
+}
+
+func TestServer_CloseConnections(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	targets, done := listen(ctx, t, client)
+	first := emulator.Connections("chat")[0].ID
+
+	emulator.CloseConnections("chat", "service restarting", true)
+	reconnected(ctx, t, emulator, "chat", first)
+	broadcast(ctx, t, client, "after")
+	assert.Equal(t, "after", receive(ctx, t, targets))
+
+	emulator.CloseConnections("chat", "goodbye", false)
+	stopped(ctx, t, done)
+}
+
+func TestServer_DelayPings(t *testing.T) {
+	emulator := newEmulator(t, signalrtest.ServerWithPingInterval(20*time.Millisecond))
+	defer emulator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat",
+		signalr.ClientWithServerTimeout(200*time.Millisecond),
+		signalr.ClientWithMaxReconnectAttempts(0))
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.Listen(ctx, signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+			return nil
+		}))
+	}()
+
+	// pings within the server timeout keep the connection alive
+	select {
+	case err := <-errs:
+		t.Fatalf("the client stopped listening: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	emulator.DelayPings(time.Second)
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
+
+func TestServer_Throttle(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+	emulator.Throttle(1, 1500*time.Millisecond)
+
+	req, err := http.NewRequest(http.MethodPost, emulator.URL+"/client/negotiate?hub=chat", nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "2", res.Header.Get("Retry-After"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	manager, err := signalr.NewServiceManager(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+	msg, err := signalr.NewInvocationMessage("foo")
+	require.NoError(t, err)
+
+	emulator.Throttle(1, time.Second)
+	err = manager.Broadcast(ctx, msg)
+	require.Error(t, err)
+	sendErr, ok := err.(signalr.SendFailureError)
+	require.True(t, ok, "expected a SendFailureError, got %v", err)
+	assert.Equal(t, http.StatusTooManyRequests, sendErr.StatusCode)
+
+	assert.NoError(t, manager.Broadcast(ctx, msg))
+}
+
+func TestServer_CorruptFrames(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	started := make(chan struct{})
+	go func() {
+		errs <- client.Listen(ctx, signalr.NewNotifiedHandler(
+			signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+				return nil
+			}), func() {
+				close(started)
+			}))
+	}()
+
+	select {
+	case <-started:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+
+	emulator.CorruptFrames(1)
+	broadcast(ctx, t, client, "corrupted")
+
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
@@ -0,0 +1,85 @@
+package signalrtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// Completion is the response of a client to an invocation which awaited its result
+	Completion struct {
+		InvocationID string
+		// Result is the JSON encoded result of the invocation, which is empty if it returned no result
+		Result json.RawMessage
+		// Error is the error the invocation failed with, if any
+		Error string
+	}
+)
+
+// Invoke calls the target on a client connected to the hub and waits for the client to complete the invocation, as
+// a server awaiting a client result does. It fails if the client disconnects or the context ends first.
+func (s *Server) Invoke(ctx context.Context, hubName, connectionID, target string, args ...interface{}) (Completion, error) {
+	msg := payloadMessage{
+		Type:         invocationMessageType,
+		InvocationID: uuid.Must(uuid.NewRandom()).String(),
+		Target:       target,
+		Arguments:    make([]json.RawMessage, len(args)),
+	}
+	for i, arg := range args {
+		bits, err := json.Marshal(arg)
+		if err != nil {
+			return Completion{}, err
+		}
+		msg.Arguments[i] = bits
+	}
+
+	s.mu.Lock()
+	c, ok := s.hubLocked(strings.ToLower(hubName)).connections[connectionID]
+	if !ok {
+		s.mu.Unlock()
+		return Completion{}, fmt.Errorf("connection %q is not connected to hub %q", connectionID, hubName)
+	}
+	completed := make(chan Completion, 1)
+	c.calls[msg.InvocationID] = completed
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(c.calls, msg.InvocationID)
+		s.mu.Unlock()
+	}()
+
+	if err := s.deliver(ctx, c, msg); err != nil {
+		return Completion{}, err
+	}
+
+	select {
+	case completion := <-completed:
+		return completion, nil
+	case <-c.done:
+		return Completion{}, fmt.Errorf("connection %q disconnected before completing the invocation", connectionID)
+	case <-ctx.Done():
+		return Completion{}, ctx.Err()
+	}
+}
+
+// complete passes a completion from the client to the invocation awaiting it
+func (s *Server) complete(c *connection, msg hubMessage) {
+	s.mu.Lock()
+	completed, ok := c.calls[msg.InvocationID]
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	// only the first completion of an invocation is awaited
+	select {
+	case completed <- Completion{InvocationID: msg.InvocationID, Result: msg.Result, Error: msg.Error}:
+	default:
+	}
+}
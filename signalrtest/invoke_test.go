@@ -0,0 +1,202 @@
+package signalrtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+)
+
+func TestServer_Invoke(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registry, err := signalr.NewHandlerRegistry()
+	require.NoError(t, err)
+	require.NoError(t, registry.On("Add", func(ctx context.Context, a, b int) (int, error) {
+		return a + b, nil
+	}))
+	require.NoError(t, registry.On("Fail", func(ctx context.Context) error {
+		return signalr.UpstreamError{Message: "no can do"}
+	}))
+	require.NoError(t, registry.On("Notify", func(ctx context.Context, message string) {}))
+
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	listenCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, client.Listen(listenCtx, registry))
+	}()
+	reconnected(ctx, t, emulator, "chat", "")
+	id := emulator.Connections("chat")[0].ID
+
+	completion, err := emulator.Invoke(ctx, "chat", id, "Add", 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, json.RawMessage("3"), completion.Result)
+	assert.Empty(t, completion.Error)
+
+	completion, err = emulator.Invoke(ctx, "chat", id, "Notify", "hello")
+	require.NoError(t, err)
+	assert.Empty(t, completion.Result)
+	assert.Empty(t, completion.Error)
+
+	// errors are returned to the caller without ending the connection
+	completion, err = emulator.Invoke(ctx, "chat", id, "Fail")
+	require.NoError(t, err)
+	assert.Equal(t, "no can do", completion.Error)
+
+	completion, err = emulator.Invoke(ctx, "chat", id, "Missing")
+	require.NoError(t, err)
+	assert.Equal(t, "An unexpected error occurred invoking 'Missing' on the server.", completion.Error)
+
+	completion, err = emulator.Invoke(ctx, "chat", id, "Add", 2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, json.RawMessage("5"), completion.Result)
+
+	_, err = emulator.Invoke(ctx, "chat", "unknown", "Add", 1, 2)
+	assert.Error(t, err)
+
+	stop()
+	stopped(ctx, t, done)
+}
+
+func TestServer_InvokeDisconnected(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	listenCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = client.Listen(listenCtx, signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+			// the client goes away rather than completing the invocation
+			stop()
+			return nil
+		}))
+	}()
+	reconnected(ctx, t, emulator, "chat", "")
+
+	_, err = emulator.Invoke(ctx, "chat", emulator.Connections("chat")[0].ID, "Hang")
+	assert.Error(t, err)
+	stopped(ctx, t, done)
+}
+
+func TestServer_InvokeIntercepted(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var invocationIDs []string
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat", signalr.ClientWithInterceptors(
+		func(ctx context.Context, msg *signalr.InvocationMessage, next signalr.Invoker) (interface{}, bool, error) {
+			invocationIDs = append(invocationIDs, msg.InvocationID)
+			return next(ctx, msg)
+		},
+		func(ctx context.Context, msg *signalr.InvocationMessage, next signalr.Invoker) (interface{}, bool, error) {
+			// answer without calling the handler
+			return "intercepted " + msg.Target, true, nil
+		}))
+	require.NoError(t, err)
+
+	listenCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, client.Listen(listenCtx, signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+			return errors.New("the handler should not be called")
+		})))
+	}()
+	reconnected(ctx, t, emulator, "chat", "")
+
+	completion, err := emulator.Invoke(ctx, "chat", emulator.Connections("chat")[0].ID, "Add", 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`"intercepted Add"`), completion.Result)
+
+	stop()
+	stopped(ctx, t, done)
+	assert.Equal(t, []string{completion.InvocationID}, invocationIDs)
+}
+
+func TestServer_InvokePanics(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	crash := signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+		panic("boom")
+	})
+
+	// the caller of an awaited invocation is told it failed and listening continues, until a panic of an invocation
+	// which is not awaited stops the client
+	client, err := signalr.NewClient(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.Listen(ctx, crash)
+	}()
+	reconnected(ctx, t, emulator, "chat", "")
+
+	previous := emulator.Connections("chat")[0].ID
+
+	completion, err := emulator.Invoke(ctx, "chat", previous, "Crash")
+	require.NoError(t, err)
+	assert.Equal(t, "An unexpected error occurred invoking 'Crash' on the server.", completion.Error)
+
+	broadcast(ctx, t, client, "Crash")
+	select {
+	case err := <-errs:
+		require.IsType(t, signalr.HandlerPanicError{}, err)
+		assert.Equal(t, "boom", err.(signalr.HandlerPanicError).Value)
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+
+	// an error handler may keep listening after any error
+	panics := make(chan error, 10)
+	client, err = signalr.NewClient(emulator.ConnectionString(), "chat", signalr.ClientWithErrorHandler(
+		func(ctx context.Context, msg *signalr.InvocationMessage, err error) error {
+			panics <- err
+			return nil
+		}))
+	require.NoError(t, err)
+
+	listenCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, client.Listen(listenCtx, crash))
+	}()
+	reconnected(ctx, t, emulator, "chat", previous)
+
+	broadcast(ctx, t, client, "Crash")
+	_, err = emulator.Invoke(ctx, "chat", emulator.Connections("chat")[0].ID, "Crash")
+	require.NoError(t, err)
+	assert.IsType(t, signalr.HandlerPanicError{}, <-panics)
+	assert.IsType(t, signalr.HandlerPanicError{}, <-panics)
+
+	stop()
+	stopped(ctx, t, done)
+}
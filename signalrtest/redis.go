@@ -0,0 +1,343 @@
+package signalrtest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// RedisServer is an in-memory stand-in for Redis speaking the RESP protocol on a local port. It supports the
+	// commands a Redis backplane needs: PING, PUBLISH, SUBSCRIBE, UNSUBSCRIBE, SADD, SREM, SMEMBERS, SISMEMBER and DEL.
+	RedisServer struct {
+		// Addr is the host:port the server listens on
+		Addr string
+
+		listener net.Listener
+		wg       sync.WaitGroup
+
+		mu      sync.Mutex
+		sets    map[string]map[string]bool
+		subs    map[string]map[*redisClient]bool
+		clients map[*redisClient]bool
+		closed  bool
+	}
+
+	redisClient struct {
+		conn net.Conn
+		// writeMu serializes replies with messages published from other clients
+		writeMu sync.Mutex
+		writer  *bufio.Writer
+	}
+)
+
+// NewRedisServer starts a Redis stand-in listening on a local port. Close the server when done.
+func NewRedisServer() (*RedisServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &RedisServer{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		sets:     make(map[string]map[string]bool),
+		subs:     make(map[string]map[*redisClient]bool),
+		clients:  make(map[*redisClient]bool),
+	}
+
+	rs.wg.Add(1)
+	go rs.accept()
+	return rs, nil
+}
+
+// Close disconnects all clients and stops the server
+func (rs *RedisServer) Close() {
+	rs.mu.Lock()
+	rs.closed = true
+	for client := range rs.clients {
+		_ = client.conn.Close()
+	}
+	rs.mu.Unlock()
+
+	_ = rs.listener.Close()
+	rs.wg.Wait()
+}
+
+func (rs *RedisServer) accept() {
+	defer rs.wg.Done()
+	for {
+		conn, err := rs.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		client := &redisClient{conn: conn, writer: bufio.NewWriter(conn)}
+		rs.mu.Lock()
+		if rs.closed {
+			rs.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		rs.clients[client] = true
+		rs.mu.Unlock()
+
+		rs.wg.Add(1)
+		go rs.serve(client)
+	}
+}
+
+func (rs *RedisServer) serve(client *redisClient) {
+	defer rs.wg.Done()
+	defer rs.disconnect(client)
+
+	reader := bufio.NewReader(client.conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if err := rs.execute(client, strings.ToUpper(args[0]), args[1:]); err != nil {
+			return
+		}
+	}
+}
+
+func (rs *RedisServer) disconnect(client *redisClient) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.clients, client)
+	for channel, subs := range rs.subs {
+		delete(subs, client)
+		if len(subs) == 0 {
+			delete(rs.subs, channel)
+		}
+	}
+	_ = client.conn.Close()
+}
+
+// execute runs a command and writes its reply to the client
+func (rs *RedisServer) execute(client *redisClient, cmd string, args []string) error {
+	switch cmd {
+	case "PING":
+		return client.write("+PONG\r\n")
+	case "PUBLISH":
+		if len(args) != 2 {
+			return client.writeArgError(cmd)
+		}
+		return client.write(integerReply(rs.publish(args[0], args[1])))
+	case "SUBSCRIBE":
+		return rs.subscribe(client, args, true)
+	case "UNSUBSCRIBE":
+		return rs.subscribe(client, args, false)
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			return client.writeArgError(cmd)
+		}
+		return client.write(integerReply(rs.update(args[0], args[1:], cmd == "SADD")))
+	case "SMEMBERS":
+		if len(args) != 1 {
+			return client.writeArgError(cmd)
+		}
+		return client.write(arrayReply(rs.members(args[0])))
+	case "SISMEMBER":
+		if len(args) != 2 {
+			return client.writeArgError(cmd)
+		}
+		rs.mu.Lock()
+		member := rs.sets[args[0]][args[1]]
+		rs.mu.Unlock()
+		if member {
+			return client.write(integerReply(1))
+		}
+		return client.write(integerReply(0))
+	case "DEL":
+		deleted := 0
+		rs.mu.Lock()
+		for _, key := range args {
+			if _, ok := rs.sets[key]; ok {
+				delete(rs.sets, key)
+				deleted++
+			}
+		}
+		rs.mu.Unlock()
+		return client.write(integerReply(deleted))
+	}
+	return client.write(fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd))
+}
+
+func (rs *RedisServer) publish(channel, message string) int {
+	rs.mu.Lock()
+	subs := make([]*redisClient, 0, len(rs.subs[channel]))
+	for sub := range rs.subs[channel] {
+		subs = append(subs, sub)
+	}
+	rs.mu.Unlock()
+
+	reply := arrayReply([]string{"message", channel, message})
+	for _, sub := range subs {
+		_ = sub.write(reply)
+	}
+	return len(subs)
+}
+
+func (rs *RedisServer) subscribe(client *redisClient, channels []string, subscribe bool) error {
+	kind := "unsubscribe"
+	if subscribe {
+		kind = "subscribe"
+	}
+
+	for _, channel := range channels {
+		rs.mu.Lock()
+		if subscribe {
+			if rs.subs[channel] == nil {
+				rs.subs[channel] = make(map[*redisClient]bool)
+			}
+			rs.subs[channel][client] = true
+		} else {
+			delete(rs.subs[channel], client)
+		}
+
+		count := 0
+		for _, subs := range rs.subs {
+			if subs[client] {
+				count++
+			}
+		}
+		rs.mu.Unlock()
+
+		reply := fmt.Sprintf("*3\r\n%s%s%s", bulkReply(kind), bulkReply(channel), integerReply(count))
+		if err := client.write(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update adds or removes the members of the set, returning how many were added or removed
+func (rs *RedisServer) update(key string, members []string, add bool) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.sets[key] == nil {
+		rs.sets[key] = make(map[string]bool)
+	}
+
+	changed := 0
+	for _, member := range members {
+		if rs.sets[key][member] != add {
+			changed++
+		}
+		if add {
+			rs.sets[key][member] = true
+		} else {
+			delete(rs.sets[key], member)
+		}
+	}
+
+	if len(rs.sets[key]) == 0 {
+		delete(rs.sets, key)
+	}
+	return changed
+}
+
+func (rs *RedisServer) members(key string) []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	members := make([]string, 0, len(rs.sets[key]))
+	for member := range rs.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (c *redisClient) write(reply string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.writer.WriteString(reply); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+func (c *redisClient) writeArgError(cmd string) error {
+	return c.write(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(cmd)))
+}
+
+func integerReply(n int) string {
+	return ":" + strconv.Itoa(n) + "\r\n"
+}
+
+func bulkReply(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func arrayReply(items []string) string {
+	var reply strings.Builder
+	reply.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		reply.WriteString(bulkReply(item))
+	}
+	return reply.String()
+}
+
+// readCommand reads a command sent as a RESP array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		header, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(header, "$") {
+			return nil, errors.New("expected a bulk string")
+		}
+
+		size, err := strconv.Atoi(header[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, bulk); err != nil {
+			return nil, err
+		}
+		args[i] = string(bulk[:size])
+	}
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
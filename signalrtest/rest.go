@@ -0,0 +1,284 @@
+package signalrtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type (
+	restRequest struct {
+		w        http.ResponseWriter
+		r        *http.Request
+		hub      string
+		segments []string
+	}
+
+	// selector selects connections of a hub; s.mu is held while it is called
+	selector func(h *hub, c *connection) bool
+)
+
+const (
+	maxGenerateTokenMinutes = 60 * 24 * 365
+)
+
+// rest serves the versioned management REST API rooted at /api/hubs/{hub}
+func (s *Server) rest(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.authorize(r, s.URL+r.URL.EscapedPath()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	raw := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/api/hubs/"), "/")
+	segments := make([]string, len(raw))
+	for i, segment := range raw {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		segments[i] = unescaped
+	}
+
+	req := &restRequest{
+		w:        w,
+		r:        r,
+		hub:      strings.ToLower(segments[0]),
+		segments: segments[1:],
+	}
+
+	if !s.route(req) {
+		http.NotFound(w, r)
+	}
+}
+
+// route dispatches the request to the operation matching its method and path, returning false if there is none
+func (s *Server) route(req *restRequest) bool {
+	method, seg := req.r.Method, req.segments
+	switch {
+	case method == http.MethodPost && match(seg, ":send"):
+		s.send(req, func(h *hub, c *connection) bool {
+			return true
+		})
+	case method == http.MethodPost && match(seg, ":generateToken"):
+		s.generateToken(req)
+	case method == http.MethodPost && match(seg, "users", "*", ":send"):
+		s.send(req, func(h *hub, c *connection) bool {
+			return c.userID == seg[1]
+		})
+	case method == http.MethodPost && match(seg, "groups", "*", ":send"):
+		s.send(req, func(h *hub, c *connection) bool {
+			return h.groupsOf(c)[seg[1]]
+		})
+	case method == http.MethodPost && match(seg, "connections", "*", ":send"):
+		s.send(req, func(h *hub, c *connection) bool {
+			return c.id == seg[1]
+		})
+	case method == http.MethodHead && match(seg, "users", "*"):
+		s.exists(req, func(h *hub, c *connection) bool {
+			return c.userID == seg[1]
+		})
+	case method == http.MethodHead && match(seg, "groups", "*"):
+		s.exists(req, func(h *hub, c *connection) bool {
+			return h.groupsOf(c)[seg[1]]
+		})
+	case method == http.MethodHead && match(seg, "connections", "*"):
+		s.exists(req, func(h *hub, c *connection) bool {
+			return c.id == seg[1]
+		})
+	case method == http.MethodHead && match(seg, "users", "*", "groups", "*"):
+		s.exists(req, func(h *hub, c *connection) bool {
+			return c.userID == seg[1] && h.userGroups[seg[1]][seg[3]]
+		})
+	case match(seg, "groups", "*", "users", "*"):
+		return s.userGroup(req, seg[3], seg[1])
+	case match(seg, "users", "*", "groups", "*"):
+		return s.userGroup(req, seg[1], seg[3])
+	case method == http.MethodDelete && match(seg, "users", "*", "groups"):
+		s.update(req, func(h *hub) int {
+			delete(h.userGroups, seg[1])
+			return http.StatusOK
+		})
+	case match(seg, "groups", "*", "connections", "*"):
+		return s.connectionGroup(req, seg[3], seg[1])
+	case match(seg, "connections", "*", "groups", "*"):
+		return s.connectionGroup(req, seg[1], seg[3])
+	case method == http.MethodDelete && match(seg, "connections", "*", "groups"):
+		s.update(req, func(h *hub) int {
+			if c, ok := h.connections[seg[1]]; ok {
+				c.groups = make(map[string]bool)
+			}
+			return http.StatusOK
+		})
+	case method == http.MethodDelete && match(seg, "connections", "*"):
+		s.closeConnection(req, seg[1])
+	default:
+		return false
+	}
+	return true
+}
+
+// match reports whether the path segments match the pattern, where "*" matches any segment
+func match(segments []string, pattern ...string) bool {
+	if len(segments) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// send delivers the invocation in the request body to each selected connection of the hub
+func (s *Server) send(req *restRequest, selected selector) {
+	var msg payloadMessage
+	if err := json.NewDecoder(req.r.Body).Decode(&msg); err != nil {
+		http.Error(req.w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg.Type = invocationMessageType
+
+	var targets []*connection
+	s.mu.Lock()
+	if h, ok := s.hubs[req.hub]; ok {
+		for _, c := range h.connections {
+			if selected(h, c) {
+				targets = append(targets, c)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		// a failed delivery is a client going away, which the service does not report to the sender
+		_ = s.deliver(req.r.Context(), c, msg)
+	}
+	req.w.WriteHeader(http.StatusAccepted)
+}
+
+// exists responds 200 OK if any connection of the hub is selected, otherwise 404 Not Found
+func (s *Server) exists(req *restRequest, selected selector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if h, ok := s.hubs[req.hub]; ok {
+		for _, c := range h.connections {
+			if selected(h, c) {
+				req.w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+	}
+	req.w.WriteHeader(http.StatusNotFound)
+}
+
+// update applies a change to the hub, responding with the status it returns
+func (s *Server) update(req *restRequest, apply func(h *hub) int) {
+	s.mu.Lock()
+	status := apply(s.hubLocked(req.hub))
+	s.mu.Unlock()
+	req.w.WriteHeader(status)
+}
+
+// userGroup adds or removes a user from a group. Membership applies to current and future connections of the user.
+func (s *Server) userGroup(req *restRequest, userID, group string) bool {
+	switch req.r.Method {
+	case http.MethodPut:
+		s.update(req, func(h *hub) int {
+			if h.userGroups[userID] == nil {
+				h.userGroups[userID] = make(map[string]bool)
+			}
+			h.userGroups[userID][group] = true
+			return http.StatusOK
+		})
+	case http.MethodDelete:
+		s.update(req, func(h *hub) int {
+			delete(h.userGroups[userID], group)
+			return http.StatusOK
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// connectionGroup adds or removes a connection from a group
+func (s *Server) connectionGroup(req *restRequest, connectionID, group string) bool {
+	switch req.r.Method {
+	case http.MethodPut:
+		s.update(req, func(h *hub) int {
+			c, ok := h.connections[connectionID]
+			if !ok {
+				return http.StatusNotFound
+			}
+			c.groups[group] = true
+			return http.StatusOK
+		})
+	case http.MethodDelete:
+		s.update(req, func(h *hub) int {
+			if c, ok := h.connections[connectionID]; ok {
+				delete(c.groups, group)
+			}
+			return http.StatusOK
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// closeConnection sends a close message with the reason to the connection and disconnects it
+func (s *Server) closeConnection(req *restRequest, connectionID string) {
+	s.mu.Lock()
+	var c *connection
+	if h, ok := s.hubs[req.hub]; ok {
+		c = h.connections[connectionID]
+	}
+	s.mu.Unlock()
+
+	if c != nil {
+		s.close(req.r.Context(), c, closeMessage{Type: closeMessageType, Error: req.r.URL.Query().Get("reason")})
+	}
+	req.w.WriteHeader(http.StatusOK)
+}
+
+// generateToken issues a client access token for the hub, as the service does for callers authenticating with Azure AD
+func (s *Server) generateToken(req *restRequest) {
+	query := req.r.URL.Query()
+	minutes := 60
+	if raw := query.Get("minutesToExpire"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > maxGenerateTokenMinutes {
+			http.Error(req.w, "minutesToExpire is invalid", http.StatusBadRequest)
+			return
+		}
+		minutes = parsed
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"aud": s.clientAudience(req.hub),
+		"iat": now.Unix(),
+		"exp": now.Add(time.Duration(minutes) * time.Minute).Unix(),
+	}
+	if userID := query.Get("userId"); userID != "" {
+		claims["nameid"] = userID
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.accessKey))
+	if err != nil {
+		http.Error(req.w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(req.w, http.StatusOK, struct {
+		Token string `json:"token"`
+	}{Token: token})
+}
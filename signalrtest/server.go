@@ -0,0 +1,536 @@
+// Package signalrtest provides an in-memory emulator of the Azure SignalR service so applications using
+// github.com/devigned/signalr-go can be tested without provisioning a SignalR resource.
+//
+// The emulator serves negotiation, the WebSocket client endpoint speaking the JSON hub protocol, and the versioned
+// management REST API for broadcasting and for managing users, groups and connections. Every request must carry a
+// token signed with the emulator's access key for the expected audience, just as the service requires.
+package signalrtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"nhooyr.io/websocket"
+)
+
+type (
+	// Server is an in-memory emulator of a SignalR service instance listening on a local `httptest.Server`
+	Server struct {
+		// URL is the endpoint of the emulator, of the form http://ipaddr:port with no trailing slash
+		URL string
+
+		server       *httptest.Server
+		accessKey    string
+		pingInterval time.Duration
+
+		mu      sync.Mutex
+		hubs    map[string]*hub
+		pending map[string]*connection
+		faults  faults
+	}
+
+	// ServerOption provides a way to configure the emulator at time of construction
+	ServerOption func(*Server) error
+
+	// Connection describes a client connected to the emulator
+	Connection struct {
+		ID     string
+		UserID string
+		Groups []string
+	}
+
+	hub struct {
+		connections map[string]*connection
+		userGroups  map[string]map[string]bool
+	}
+
+	connection struct {
+		id     string
+		hub    string
+		userID string
+		groups map[string]bool
+		conn   *websocket.Conn
+		// netConn is the network connection underlying the WebSocket
+		netConn net.Conn
+		// writeMu orders writes so nothing is sent before the handshake response; it guards sent
+		writeMu sync.Mutex
+		// sent is the number of invocations sent to the connection, which is dropped once it reaches dropAfter unless
+		// dropAfter is negative
+		sent      int
+		dropAfter int
+		// calls are the invocations awaiting a completion from the client by invocation ID; s.mu must be held
+		calls map[string]chan Completion
+		// done is closed once the client has disconnected
+		done chan struct{}
+	}
+
+	payloadMessage struct {
+		Type         int               `json:"type"`
+		InvocationID string            `json:"invocationId,omitempty"`
+		Target       string            `json:"target"`
+		Arguments    []json.RawMessage `json:"arguments"`
+	}
+
+	hubMessage struct {
+		Type         int             `json:"type"`
+		InvocationID string          `json:"invocationId,omitempty"`
+		Result       json.RawMessage `json:"result,omitempty"`
+		Error        string          `json:"error,omitempty"`
+	}
+
+	handshakeRequest struct {
+		Protocol string `json:"protocol"`
+		Version  int    `json:"version"`
+	}
+
+	handshakeResponse struct {
+		Error string `json:"error,omitempty"`
+	}
+
+	negotiateResponse struct {
+		ConnectionID        string      `json:"connectionId"`
+		AvailableTransports []transport `json:"availableTransports"`
+	}
+
+	transport struct {
+		Transport       string   `json:"transport"`
+		TransferFormats []string `json:"transferFormats"`
+	}
+)
+
+const (
+	// DefaultAccessKey is the access key the emulator accepts tokens signed with unless another is configured
+	DefaultAccessKey = "signalrtest-access-key"
+
+	// DefaultPingInterval is how often the emulator pings connected clients unless another interval is configured
+	DefaultPingInterval = 15 * time.Second
+
+	recordSeparator = 0x1E
+
+	invocationMessageType = 1
+	completionMessageType = 3
+	pingMessageType       = 6
+	closeMessageType      = 7
+
+	writeTimeout = 5 * time.Second
+)
+
+// ServerWithAccessKey configures the access key tokens must be signed with
+func ServerWithAccessKey(key string) ServerOption {
+	return func(s *Server) error {
+		if key == "" {
+			return errors.New("access key must not be empty")
+		}
+		s.accessKey = key
+		return nil
+	}
+}
+
+// ServerWithPingInterval configures how often the emulator pings connected clients
+func ServerWithPingInterval(interval time.Duration) ServerOption {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("ping interval must be positive")
+		}
+		s.pingInterval = interval
+		return nil
+	}
+}
+
+// NewServer starts an emulator listening on a local port. Close the emulator when done.
+func NewServer(opts ...ServerOption) (*Server, error) {
+	s := &Server{
+		accessKey:    DefaultAccessKey,
+		pingInterval: DefaultPingInterval,
+		hubs:         make(map[string]*hub),
+		pending:      make(map[string]*connection),
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s, nil
+}
+
+// ConnectionString returns a connection string addressing the emulator
+func (s *Server) ConnectionString() string {
+	return fmt.Sprintf("Endpoint=%s;AccessKey=%s;Version=1.0;", s.URL, s.accessKey)
+}
+
+// Close disconnects all clients and shuts down the emulator
+func (s *Server) Close() {
+	s.mu.Lock()
+	var conns []*connection
+	for _, h := range s.hubs {
+		for _, c := range h.connections {
+			conns = append(conns, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		_ = c.conn.Close(websocket.StatusGoingAway, "the emulator is shutting down")
+	}
+	s.server.Close()
+}
+
+// Connections returns the clients connected to the hub sorted by connection ID
+func (s *Server) Connections(hubName string) []Connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hubs[strings.ToLower(hubName)]
+	if !ok {
+		return nil
+	}
+
+	conns := make([]Connection, 0, len(h.connections))
+	for _, c := range h.connections {
+		var groups []string
+		for group := range h.groupsOf(c) {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+		conns = append(conns, Connection{ID: c.id, UserID: c.userID, Groups: groups})
+	}
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ID < conns[j].ID
+	})
+	return conns
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	switch {
+	case path == "/client/negotiate":
+		if !s.throttle(w) {
+			s.negotiate(w, r)
+		}
+	case path == "/client/" || path == "/client":
+		s.connect(w, r)
+	case path == "/api/health":
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(path, "/api/hubs/"):
+		if !s.throttle(w) {
+			s.rest(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize validates the bearer token of the request, or its access_token query parameter as sent by browsers, for
+// the audience and returns its claims
+func (s *Server) authorize(r *http.Request, audience string) (jwt.MapClaims, error) {
+	raw := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		raw = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	if raw == "" {
+		return nil, errors.New("missing access token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(s.accessKey), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if aud, _ := claims["aud"].(string); !strings.EqualFold(aud, audience) {
+		return nil, fmt.Errorf("the token audience %q does not match %q", aud, audience)
+	}
+	return claims, nil
+}
+
+func (s *Server) clientAudience(hubName string) string {
+	return s.URL + "/client/?hub=" + hubName
+}
+
+// hubLocked returns the hub, creating it if it does not exist; s.mu must be held
+func (s *Server) hubLocked(name string) *hub {
+	h, ok := s.hubs[name]
+	if !ok {
+		h = &hub{
+			connections: make(map[string]*connection),
+			userGroups:  make(map[string]map[string]bool),
+		}
+		s.hubs[name] = h
+	}
+	return h
+}
+
+func (s *Server) negotiate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	hubName := strings.ToLower(r.URL.Query().Get("hub"))
+	claims, err := s.authorize(r, s.clientAudience(hubName))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	userID, _ := claims["nameid"].(string)
+	c := &connection{
+		id:     uuid.Must(uuid.NewRandom()).String(),
+		hub:    hubName,
+		userID: userID,
+		groups: make(map[string]bool),
+		calls:  make(map[string]chan Completion),
+		done:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.pending[c.id] = c
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, negotiateResponse{
+		ConnectionID: c.id,
+		AvailableTransports: []transport{
+			{Transport: "WebSockets", TransferFormats: []string{"Text"}},
+		},
+	})
+}
+
+func (s *Server) connect(w http.ResponseWriter, r *http.Request) {
+	hubName := strings.ToLower(r.URL.Query().Get("hub"))
+	if _, err := s.authorize(r, s.clientAudience(hubName)); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	s.mu.Lock()
+	c, ok := s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+
+	if !ok || c.hub != hubName {
+		http.Error(w, "the connection was not negotiated", http.StatusNotFound)
+		return
+	}
+
+	hr := &hijackRecorder{ResponseWriter: w}
+	conn, err := websocket.Accept(hr, r, websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	c.conn = conn
+	c.netConn = hr.conn
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+		close(c.done)
+	}()
+
+	ctx := r.Context()
+	if err := s.handshake(ctx, c); err != nil {
+		return
+	}
+	defer s.remove(c)
+
+	pingCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.ping(pingCtx, c)
+
+	for {
+		_, reader, err := conn.Reader(ctx)
+		if err != nil {
+			return
+		}
+
+		bits, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return
+		}
+
+		for _, record := range bytes.Split(bits, []byte{recordSeparator}) {
+			if len(record) == 0 {
+				continue
+			}
+
+			var msg hubMessage
+			if err := json.Unmarshal(record, &msg); err != nil {
+				return
+			}
+
+			switch msg.Type {
+			case completionMessageType:
+				s.complete(c, msg)
+			case closeMessageType:
+				return
+			}
+		}
+	}
+}
+
+// handshake completes the hub protocol handshake and registers the connection before responding so messages sent
+// once the client has connected are delivered
+func (s *Server) handshake(ctx context.Context, c *connection) error {
+	_, reader, err := c.conn.Reader(ctx)
+	if err != nil {
+		return err
+	}
+
+	bits, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	var req handshakeRequest
+	if err := json.Unmarshal(bytes.TrimSuffix(bits, []byte{recordSeparator}), &req); err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	msg := s.handshakeError()
+	if req.Protocol != "json" || req.Version != 1 {
+		msg = fmt.Sprintf("the protocol %q version %d is not supported", req.Protocol, req.Version)
+	}
+
+	if msg != "" {
+		_ = c.writeLocked(ctx, handshakeResponse{Error: msg})
+		return errors.New(msg)
+	}
+
+	s.mu.Lock()
+	s.hubLocked(c.hub).connections[c.id] = c
+	s.armDropLocked(c)
+	s.mu.Unlock()
+
+	if err := c.writeLocked(ctx, handshakeResponse{}); err != nil {
+		return err
+	}
+
+	if c.dropAfter == 0 {
+		s.drop(c)
+	}
+	return nil
+}
+
+func (s *Server) remove(c *connection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.hubs[c.hub]; ok {
+		delete(h.connections, c.id)
+	}
+}
+
+func (s *Server) ping(ctx context.Context, c *connection) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.nextPing()):
+			if err := c.write(ctx, hubMessage{Type: pingMessageType}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// deliver sends an invocation to the client, applying any scripted corruption or drop
+func (s *Server) deliver(ctx context.Context, c *connection, msg payloadMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var err error
+	if s.corrupt() {
+		err = c.writeFrame(ctx, []byte(corruptFrame))
+	} else {
+		err = c.writeLocked(ctx, msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.sent++
+	if c.dropAfter >= 0 && c.sent >= c.dropAfter {
+		s.drop(c)
+	}
+	return nil
+}
+
+// close sends the close message to the client and disconnects it
+func (s *Server) close(ctx context.Context, c *connection, msg closeMessage) {
+	_ = c.write(ctx, msg)
+	s.remove(c)
+	_ = c.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+// write sends a single JSON hub protocol record to the client
+func (c *connection) write(ctx context.Context, msg interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeLocked(ctx, msg)
+}
+
+func (c *connection) writeLocked(ctx context.Context, msg interface{}) error {
+	bits, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(ctx, bits)
+}
+
+// writeFrame sends the bits as a terminated record in a single text frame; c.writeMu must be held
+func (c *connection) writeFrame(ctx context.Context, bits []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	writer, err := c.conn.Writer(ctx, websocket.MessageText)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(append(bits, recordSeparator)); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// groupsOf returns the groups the connection is a member of, either directly or through its user; s.mu must be held
+func (h *hub) groupsOf(c *connection) map[string]bool {
+	groups := make(map[string]bool, len(c.groups))
+	for group := range c.groups {
+		groups[group] = true
+	}
+	for group := range h.userGroups[c.userID] {
+		groups[group] = true
+	}
+	return groups
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,158 @@
+package signalrtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+	"github.com/devigned/signalr-go/signalrtest"
+)
+
+func newEmulator(t *testing.T, opts ...signalrtest.ServerOption) *signalrtest.Server {
+	emulator, err := signalrtest.NewServer(opts...)
+	require.NoError(t, err)
+	return emulator
+}
+
+// listen starts listening with the client, returning a channel of the targets invoked on it and a channel which
+// closes once Listen returns
+func listen(ctx context.Context, t *testing.T, client *signalr.Client) (<-chan string, <-chan struct{}) {
+	started := make(chan struct{})
+	targets := make(chan string, 10)
+	done := make(chan struct{})
+
+	handler := signalr.NewNotifiedHandler(
+		signalr.HandlerFunc(func(ctx context.Context, target string, args []json.RawMessage) error {
+			targets <- target
+			return nil
+		}), func() {
+			close(started)
+		})
+
+	go func() {
+		defer close(done)
+		_ = client.Listen(ctx, handler)
+	}()
+
+	select {
+	case <-started:
+	case <-done:
+		t.Fatal("the client stopped listening before it connected")
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	return targets, done
+}
+
+func TestServer_Unauthorized(t *testing.T) {
+	emulator := newEmulator(t, signalrtest.ServerWithAccessKey("expected"))
+	defer emulator.Close()
+
+	client, err := signalr.NewClient("Endpoint="+emulator.URL+";AccessKey=forged;Version=1.0;", "chat")
+	require.NoError(t, err)
+
+	msg, err := signalr.NewInvocationMessage("foo")
+	require.NoError(t, err)
+
+	err = client.BroadcastAll(context.Background(), msg)
+	require.Error(t, err)
+	sendErr, ok := err.(signalr.SendFailureError)
+	require.True(t, ok, "expected a SendFailureError, got %v", err)
+	assert.Equal(t, http.StatusUnauthorized, sendErr.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Error(t, client.Listen(ctx, signalr.HandlerFunc(func(context.Context, string, []json.RawMessage) error {
+		return nil
+	})))
+}
+
+func TestServer_Connections(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := signalr.NewClient(emulator.ConnectionString(), "Chat", signalr.ClientWithName("user1"))
+	require.NoError(t, err)
+	require.NoError(t, client.AddUserToGroup(ctx, "room", "user1"))
+
+	targets, done := listen(ctx, t, client)
+
+	conns := emulator.Connections("chat")
+	require.Len(t, conns, 1)
+	assert.Equal(t, "user1", conns[0].UserID)
+	assert.Equal(t, []string{"room"}, conns[0].Groups)
+
+	manager, err := signalr.NewServiceManager(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	exists, err := manager.ConnectionExists(ctx, conns[0].ID)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = manager.GroupExists(ctx, "lobby")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	for _, send := range []func(msg *signalr.InvocationMessage) error{
+		func(msg *signalr.InvocationMessage) error { return manager.Broadcast(ctx, msg) },
+		func(msg *signalr.InvocationMessage) error { return manager.SendToUser(ctx, msg, "user2") },
+		func(msg *signalr.InvocationMessage) error { return manager.SendToUser(ctx, msg, "user1") },
+		func(msg *signalr.InvocationMessage) error { return manager.SendToGroup(ctx, msg, "lobby") },
+		func(msg *signalr.InvocationMessage) error { return manager.SendToGroup(ctx, msg, "room") },
+		func(msg *signalr.InvocationMessage) error { return manager.SendToConnection(ctx, msg, conns[0].ID) },
+	} {
+		msg, err := signalr.NewInvocationMessage("target")
+		require.NoError(t, err)
+		require.NoError(t, send(msg))
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case target := <-targets:
+			assert.Equal(t, "target", target)
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+
+	require.NoError(t, manager.CloseConnection(ctx, conns[0].ID, "done"))
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+
+	assert.Empty(t, emulator.Connections("chat"))
+	assert.Empty(t, targets)
+}
+
+func TestServer_GenerateClientToken(t *testing.T) {
+	emulator := newEmulator(t)
+	defer emulator.Close()
+
+	manager, err := signalr.NewServiceManager(emulator.ConnectionString(), "chat")
+	require.NoError(t, err)
+
+	token, err := manager.GenerateClientToken(context.Background(), "user1", time.Hour)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, emulator.URL+"/client/negotiate?hub=chat", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
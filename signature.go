@@ -0,0 +1,127 @@
+package signalr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type (
+	// UpstreamSignatureValidator verifies the X-ASRS-Signature header of upstream requests. The service signs the
+	// connection ID of each request with every access key of the resource, so requests are accepted if any of the
+	// signatures matches any of the validator's keys. Provide both the primary and secondary keys to keep accepting
+	// requests while a key is rotated.
+	UpstreamSignatureValidator struct {
+		mu   sync.RWMutex
+		keys [][]byte
+	}
+)
+
+const (
+	signatureHeader = "X-ASRS-Signature"
+	signaturePrefix = "sha256="
+)
+
+// UpstreamWithSignatureValidation configures the upstream handler to reject requests which are not signed with any of
+// the access keys with 401 Unauthorized
+func UpstreamWithSignatureValidation(keys ...string) UpstreamHandlerOption {
+	return func(uh *upstreamHandler) error {
+		validator, err := NewUpstreamSignatureValidator(keys...)
+		if err != nil {
+			return err
+		}
+		uh.validator = validator
+		return nil
+	}
+}
+
+// NewUpstreamSignatureValidator creates a validator accepting upstream requests signed with any of the access keys
+func NewUpstreamSignatureValidator(keys ...string) (*UpstreamSignatureValidator, error) {
+	v := new(UpstreamSignatureValidator)
+	if err := v.UpdateKeys(keys...); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// UpdateKeys replaces the access keys the validator accepts signatures for
+func (v *UpstreamSignatureValidator) UpdateKeys(keys ...string) error {
+	if len(keys) == 0 {
+		return errors.New("at least one access key is required to validate upstream signatures")
+	}
+
+	keyBits := make([][]byte, len(keys))
+	for i, key := range keys {
+		if key == "" {
+			return errors.New("access keys must not be empty")
+		}
+		keyBits[i] = []byte(key)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys = keyBits
+	return nil
+}
+
+// Validate returns an error unless the request carries a signature of its connection ID made with one of the keys
+func (v *UpstreamSignatureValidator) Validate(r *http.Request) error {
+	connectionID := r.Header.Get("X-ASRS-Connection-Id")
+	if connectionID == "" {
+		return errors.New("the upstream request does not have a connection ID")
+	}
+
+	header := r.Header.Get(signatureHeader)
+	if header == "" {
+		return errors.New("the upstream request is not signed")
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	expected := make([][]byte, len(v.keys))
+	for i, key := range v.keys {
+		mac := hmac.New(sha256.New, key)
+		_, _ = mac.Write([]byte(connectionID))
+		expected[i] = mac.Sum(nil)
+	}
+
+	valid := false
+	for _, signature := range strings.Split(header, ",") {
+		signature = strings.TrimSpace(signature)
+		if !strings.HasPrefix(strings.ToLower(signature), signaturePrefix) {
+			continue
+		}
+
+		actual, err := hex.DecodeString(signature[len(signaturePrefix):])
+		if err != nil {
+			continue
+		}
+
+		// compare against every key so the time taken does not reveal which key matched
+		for _, exp := range expected {
+			if hmac.Equal(actual, exp) {
+				valid = true
+			}
+		}
+	}
+
+	if !valid {
+		return errors.New("the upstream request signature is invalid")
+	}
+	return nil
+}
+
+// Middleware wraps an `http.Handler`, rejecting requests which fail validation with 401 Unauthorized
+func (v *UpstreamSignatureValidator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Validate(r); err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
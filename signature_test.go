@@ -0,0 +1,78 @@
+package signalr_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+)
+
+func sign(key, connectionID string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write([]byte(connectionID))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestUpstreamSignatureValidator(t *testing.T) {
+	validator, err := signalr.NewUpstreamSignatureValidator("primary", "secondary")
+	require.NoError(t, err)
+
+	signed := func(signature string) *http.Request {
+		req := newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, "")
+		if signature != "" {
+			req.Header.Set("X-ASRS-Signature", signature)
+		}
+		return req
+	}
+
+	assert.NoError(t, validator.Validate(signed(sign("primary", "conn1"))))
+	assert.NoError(t, validator.Validate(signed(sign("other", "conn1")+","+sign("secondary", "conn1"))))
+	assert.Error(t, validator.Validate(signed(sign("other", "conn1"))))
+	assert.Error(t, validator.Validate(signed(sign("primary", "conn2"))))
+	assert.Error(t, validator.Validate(signed("sha256=zz")))
+	assert.Error(t, validator.Validate(signed("")))
+
+	require.NoError(t, validator.UpdateKeys("rotated"))
+	assert.Error(t, validator.Validate(signed(sign("primary", "conn1"))))
+	assert.NoError(t, validator.Validate(signed(sign("rotated", "conn1"))))
+
+	_, err = signalr.NewUpstreamSignatureValidator()
+	assert.Error(t, err)
+}
+
+func TestUpstreamSignatureValidator_Middleware(t *testing.T) {
+	validator, err := signalr.NewUpstreamSignatureValidator("primary")
+	require.NoError(t, err)
+
+	handler := validator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, "")
+	assert.Equal(t, http.StatusUnauthorized, serveUpstream(handler, req).Code)
+
+	req.Header.Set("X-ASRS-Signature", sign("primary", "conn1"))
+	assert.Equal(t, http.StatusNoContent, serveUpstream(handler, req).Code)
+}
+
+func TestUpstreamHandler_SignatureValidation(t *testing.T) {
+	handler, err := signalr.NewUpstreamHandler(new(upstreamRecorder), signalr.UpstreamWithSignatureValidation("primary"))
+	require.NoError(t, err)
+
+	req := newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, "")
+	req.Header.Set("X-ASRS-Signature", sign("forged", "conn1"))
+	assert.Equal(t, http.StatusUnauthorized, serveUpstream(handler, req).Code)
+
+	req = newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, "")
+	req.Header.Set("X-ASRS-Signature", sign("primary", "conn1"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
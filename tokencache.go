@@ -0,0 +1,187 @@
+package signalr
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// cachingTokenProvider caches the tokens of another provider per audience so tokens are only signed or requested
+	// from an identity endpoint when the cached token is close to expiring
+	cachingTokenProvider struct {
+		provider TokenProvider
+		cache    *tokenCache
+	}
+
+	// tokenCache holds tokens by key and refreshes them before they expire. Tokens inside their refresh window are
+	// still returned while a single background refresh replaces them; expired tokens are refreshed before returning.
+	tokenCache struct {
+		mu      sync.Mutex
+		entries map[string]*tokenCacheEntry
+		now     func() time.Time
+	}
+
+	tokenCacheEntry struct {
+		token      AccessToken
+		refreshAt  time.Time
+		refreshing bool
+		ready      chan struct{} // closed when the in-flight fetch of an entry without a token completes
+		err        error
+	}
+)
+
+const (
+	// maxTokenRefreshWindow is the longest time before expiry a cached token is refreshed
+	maxTokenRefreshWindow = 5 * time.Minute
+
+	// maxCachedTokens bounds the number of tokens cached before expired tokens are evicted
+	maxCachedTokens = 1024
+)
+
+func newCachingTokenProvider(provider TokenProvider) TokenProvider {
+	if _, ok := provider.(*cachingTokenProvider); ok {
+		return provider
+	}
+
+	return &cachingTokenProvider{
+		provider: provider,
+		cache:    newTokenCache(),
+	}
+}
+
+// unwrapTokenProvider returns the provider a caching provider wraps
+func unwrapTokenProvider(provider TokenProvider) TokenProvider {
+	if caching, ok := provider.(*cachingTokenProvider); ok {
+		return caching.provider
+	}
+	return provider
+}
+
+// GetToken returns the cached token for the audience, acquiring a new token if it is close to expiring
+func (p *cachingTokenProvider) GetToken(ctx context.Context, audience string) (AccessToken, error) {
+	key := audience
+	if lifetime, ok := tokenLifetimeFromContext(ctx); ok {
+		key += "\n" + lifetime.String()
+	}
+
+	return p.cache.get(ctx, key, func(ctx context.Context) (AccessToken, error) {
+		return p.provider.GetToken(ctx, audience)
+	})
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		entries: make(map[string]*tokenCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns the token cached for the key, calling fetch to acquire the token if none is cached or it has expired,
+// and refreshing it in the background if it is inside its refresh window
+func (tc *tokenCache) get(ctx context.Context, key string, fetch func(ctx context.Context) (AccessToken, error)) (AccessToken, error) {
+	for {
+		tc.mu.Lock()
+		now := tc.now()
+		entry, ok := tc.entries[key]
+		switch {
+		case ok && entry.ready != nil:
+			// another caller is acquiring the token; wait for it rather than making a duplicate request
+			ready := entry.ready
+			tc.mu.Unlock()
+			select {
+			case <-ready:
+				continue
+			case <-ctx.Done():
+				return AccessToken{}, ctx.Err()
+			}
+		case ok && entry.err == nil && now.Before(entry.token.ExpiresOn):
+			if !now.Before(entry.refreshAt) && !entry.refreshing {
+				entry.refreshing = true
+				go tc.refresh(key, entry, fetch)
+			}
+			token := entry.token
+			tc.mu.Unlock()
+			return token, nil
+		}
+
+		entry = &tokenCacheEntry{ready: make(chan struct{})}
+		tc.store(key, entry)
+		tc.mu.Unlock()
+
+		token, err := fetch(ctx)
+
+		tc.mu.Lock()
+		tc.fill(entry, token, err)
+		if err != nil {
+			delete(tc.entries, key)
+		}
+		close(entry.ready)
+		entry.ready = nil
+		tc.mu.Unlock()
+		return token, err
+	}
+}
+
+// refresh replaces a token inside its refresh window without blocking callers, who keep receiving the current token
+func (tc *tokenCache) refresh(key string, entry *tokenCacheEntry, fetch func(ctx context.Context) (AccessToken, error)) {
+	tc.mu.Lock()
+	deadline := entry.token.ExpiresOn
+	tc.mu.Unlock()
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	token, err := fetch(ctx)
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	entry.refreshing = false
+	if err != nil {
+		// keep the current token; the next caller inside the refresh window will try again
+		return
+	}
+
+	if tc.entries[key] == entry {
+		tc.fill(entry, token, nil)
+	}
+}
+
+// clear drops every cached token so the next caller for each key acquires a new token
+func (tc *tokenCache) clear() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.entries = make(map[string]*tokenCacheEntry)
+}
+
+// fill records a fetched token and when it should be refreshed; tc.mu must be held
+func (tc *tokenCache) fill(entry *tokenCacheEntry, token AccessToken, err error) {
+	entry.token = token
+	entry.err = err
+	window := token.ExpiresOn.Sub(tc.now()) / 2
+	if window > maxTokenRefreshWindow {
+		window = maxTokenRefreshWindow
+	}
+	entry.refreshAt = token.ExpiresOn.Add(-window)
+}
+
+// store adds an entry to the cache, evicting expired tokens if the cache is full; tc.mu must be held
+func (tc *tokenCache) store(key string, entry *tokenCacheEntry) {
+	if len(tc.entries) >= maxCachedTokens {
+		now := tc.now()
+		for k, e := range tc.entries {
+			if e.ready == nil && !now.Before(e.token.ExpiresOn) {
+				delete(tc.entries, k)
+			}
+		}
+	}
+
+	for k, e := range tc.entries {
+		if len(tc.entries) < maxCachedTokens {
+			break
+		}
+		if e.ready == nil {
+			delete(tc.entries, k)
+		}
+	}
+	tc.entries[key] = entry
+}
@@ -0,0 +1,145 @@
+package signalr
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	countingTokenProvider struct {
+		calls    int32
+		lifetime time.Duration
+		now      func() time.Time
+	}
+)
+
+func (p *countingTokenProvider) GetToken(_ context.Context, audience string) (AccessToken, error) {
+	n := atomic.AddInt32(&p.calls, 1)
+	return AccessToken{
+		Token:     audience + "-" + strconv.Itoa(int(n)),
+		ExpiresOn: p.now().Add(p.lifetime),
+	}, nil
+}
+
+func (p *countingTokenProvider) callCount() int {
+	return int(atomic.LoadInt32(&p.calls))
+}
+
+func TestCachingTokenProvider_CachesPerAudience(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingTokenProvider{lifetime: time.Hour, now: time.Now}
+	provider := newCachingTokenProvider(inner)
+
+	first, err := provider.GetToken(ctx, "a")
+	require.NoError(t, err)
+	second, err := provider.GetToken(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, inner.callCount())
+
+	other, err := provider.GetToken(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "b-2", other.Token)
+	assert.Equal(t, 2, inner.callCount())
+}
+
+func TestCachingTokenProvider_RefreshesBeforeExpiry(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	now := time.Now()
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+
+	inner := &countingTokenProvider{lifetime: time.Hour, now: clock}
+	provider := newCachingTokenProvider(inner).(*cachingTokenProvider)
+	provider.cache.now = clock
+
+	first, err := provider.GetToken(ctx, "a")
+	require.NoError(t, err)
+
+	// inside the refresh window the current token is returned while a refresh happens in the background
+	advance(57 * time.Minute)
+	current, err := provider.GetToken(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, first.Token, current.Token)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for inner.callCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, 2, inner.callCount())
+
+	var refreshed AccessToken
+	for time.Now().Before(deadline) {
+		refreshed, err = provider.GetToken(ctx, "a")
+		require.NoError(t, err)
+		if refreshed.Token != first.Token {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, "a-2", refreshed.Token)
+
+	// once expired, the token is replaced before returning
+	advance(2 * time.Hour)
+	expired, err := provider.GetToken(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a-3", expired.Token)
+}
+
+func TestCachingTokenProvider_SingleFetchForConcurrentCallers(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	cache := newTokenCache()
+	fetch := func(ctx context.Context) (AccessToken, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := cache.get(context.Background(), "key", fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, "token", token.Token)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestServiceManager_CachesClientTokens(t *testing.T) {
+	sm, err := NewServiceManager("Endpoint=https://foo.service.signalr.net;AccessKey=foo", "hub1")
+	require.NoError(t, err)
+
+	first, err := sm.GenerateClientToken(context.Background(), "user1", time.Hour)
+	require.NoError(t, err)
+	second, err := sm.GenerateClientToken(context.Background(), "user1", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	other, err := sm.GenerateClientToken(context.Background(), "user2", time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, other)
+}
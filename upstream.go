@@ -0,0 +1,447 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type (
+	// UpstreamRequest describes an event the SignalR service sent to the upstream in serverless mode
+	UpstreamRequest struct {
+		Hub          string
+		Category     string
+		Event        string
+		ConnectionID string
+		UserID       string
+		// Query is the query string the client connected with
+		Query url.Values
+		// Header holds all of the headers of the request, including the X-ASRS-* headers
+		Header http.Header
+	}
+
+	// ConnectedHandler is a `Handler` which admits clients connecting to a hub in serverless mode. Return a
+	// `ConnectResponse` to change the user ID, groups or claims of the connection, or nil to accept it as is. Returning
+	// an error rejects the connection with 401 Unauthorized, or the status code of an `UpstreamError`.
+	ConnectedHandler interface {
+		Handler
+		OnConnected(ctx context.Context, req *UpstreamRequest) (*ConnectResponse, error)
+	}
+
+	// ConnectResponse is the body of the response to a connected event
+	ConnectResponse struct {
+		// UserID replaces the user ID of the connection
+		UserID string `json:"userId,omitempty"`
+		// Groups are joined by the connection
+		Groups []string `json:"groups,omitempty"`
+		// Claims are added to the claims of the connection
+		Claims map[string]interface{} `json:"claims,omitempty"`
+	}
+
+	// DisconnectedHandler is a `Handler` which is notified when a client disconnects from a hub in serverless mode. The
+	// reason is empty unless the connection closed with an error.
+	DisconnectedHandler interface {
+		Handler
+		OnDisconnected(ctx context.Context, req *UpstreamRequest, reason string) error
+	}
+
+	// UpstreamHandlerOption provides a way to configure an upstream handler at time of construction
+	UpstreamHandlerOption func(*upstreamHandler) error
+
+	upstreamHandler struct {
+		handler      Handler
+		hubs         map[string]bool
+		validator    *UpstreamSignatureValidator
+		interceptors []Interceptor
+		invoker      Invoker
+	}
+
+	upstreamRequestKey struct{}
+
+	// upstreamMessageError is returned when the body of an upstream request cannot be decoded
+	upstreamMessageError struct {
+		err error
+	}
+
+	disconnectedBody struct {
+		Error string `json:"error"`
+	}
+)
+
+const (
+	// UpstreamCategoryConnections is the category of connection lifecycle events
+	UpstreamCategoryConnections = "connections"
+	// UpstreamCategoryMessages is the category of invocations sent by clients
+	UpstreamCategoryMessages = "messages"
+
+	// UpstreamEventConnected is the event sent when a client connects
+	UpstreamEventConnected = "connected"
+	// UpstreamEventDisconnected is the event sent when a client disconnects
+	UpstreamEventDisconnected = "disconnected"
+
+	jsonContentType    = "application/json"
+	msgpackContentType = "application/x-msgpack"
+
+	// maxUpstreamBodySize limits the size of the bodies of upstream requests
+	maxUpstreamBodySize = 1 << 20
+)
+
+// UpstreamWithHubs restricts the upstream handler to events for the hubs; events for any other hub are rejected with
+// 404 Not Found. By default events for every hub are handled.
+func UpstreamWithHubs(hubs ...string) UpstreamHandlerOption {
+	return func(uh *upstreamHandler) error {
+		if uh.hubs == nil {
+			uh.hubs = make(map[string]bool, len(hubs))
+		}
+		for _, hub := range hubs {
+			uh.hubs[strings.ToLower(hub)] = true
+		}
+		return nil
+	}
+}
+
+// UpstreamWithInterceptors adds interceptors run around every invocation dispatched to the handler. Interceptors run
+// in the order they are added.
+func UpstreamWithInterceptors(interceptors ...Interceptor) UpstreamHandlerOption {
+	return func(uh *upstreamHandler) error {
+		for _, interceptor := range interceptors {
+			if interceptor == nil {
+				return errors.New("interceptor must not be nil")
+			}
+		}
+		uh.interceptors = append(uh.interceptors, interceptors...)
+		return nil
+	}
+}
+
+// NewUpstreamHandler creates an `http.Handler` for the upstream URL the SignalR service sends events to in serverless
+// mode. Invocations sent by clients are dispatched to the handler by target name, just as they are by `Listen`.
+// Handler methods may return a result, as in `func(ctx context.Context, args...) (T, error)`, which is returned to
+// the caller of the invocation in the protocol of the request.
+// Implement `ConnectedHandler` or `DisconnectedHandler` to be notified of connection lifecycle events. The
+// `UpstreamRequest` of an event is available from the context with `UpstreamRequestFromContext`. Use
+// `UpstreamWithSignatureValidation` to reject requests which were not sent by the service.
+func NewUpstreamHandler(handler Handler, opts ...UpstreamHandlerOption) (http.Handler, error) {
+	if handler == nil {
+		return nil, errors.New("handler must not be nil")
+	}
+
+	uh := &upstreamHandler{handler: handler}
+	for _, opt := range opts {
+		if err := opt(uh); err != nil {
+			return nil, err
+		}
+	}
+	uh.invoker = chainInterceptors(handler, uh.interceptors)
+	return uh, nil
+}
+
+// UpstreamRequestFromContext returns the upstream request being handled, if any
+func UpstreamRequestFromContext(ctx context.Context) (*UpstreamRequest, bool) {
+	req, ok := ctx.Value(upstreamRequestKey{}).(*UpstreamRequest)
+	return req, ok
+}
+
+func (uh *upstreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if uh.validator != nil {
+		if err := uh.validator.Validate(r); err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	req := newUpstreamRequest(r)
+	if uh.hubs != nil && !uh.hubs[strings.ToLower(req.Hub)] {
+		http.Error(w, "unknown hub", http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxUpstreamBodySize))
+	if err != nil {
+		// the reader returns everything up to the limit before failing, so a full body means it was too large
+		status := http.StatusBadRequest
+		if len(body) >= maxUpstreamBodySize {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), upstreamRequestKey{}, req)
+	switch {
+	case req.Category == UpstreamCategoryConnections && req.Event == UpstreamEventConnected:
+		uh.connected(ctx, w, req)
+		return
+	case req.Category == UpstreamCategoryConnections && req.Event == UpstreamEventDisconnected:
+		err = uh.disconnected(ctx, req, body)
+	case req.Category == UpstreamCategoryMessages:
+		uh.messages(ctx, w, r.Header.Get("Content-Type"), body)
+		return
+	default:
+		http.Error(w, fmt.Sprintf("unknown event %s/%s", req.Category, req.Event), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		writeUpstreamError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (uh *upstreamHandler) connected(ctx context.Context, w http.ResponseWriter, req *UpstreamRequest) {
+	h, ok := uh.handler.(ConnectedHandler)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	res, err := h.OnConnected(ctx, req)
+	if err != nil {
+		writeUpstreamError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if res == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	bits, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(bits)
+}
+
+// writeUpstreamError responds with the status code of an `UpstreamError`, 400 Bad Request if the request could not be
+// decoded, or the default status code for any other error
+func writeUpstreamError(w http.ResponseWriter, err error, defaultStatusCode int) {
+	switch e := err.(type) {
+	case UpstreamError:
+		http.Error(w, e.Message, e.StatusCode)
+	case upstreamMessageError:
+		http.Error(w, e.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, http.StatusText(defaultStatusCode), defaultStatusCode)
+	}
+}
+
+func (uh *upstreamHandler) disconnected(ctx context.Context, req *UpstreamRequest, body []byte) error {
+	h, ok := uh.handler.(DisconnectedHandler)
+	if !ok {
+		return nil
+	}
+
+	var reason disconnectedBody
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &reason); err != nil {
+			return upstreamMessageError{err: err}
+		}
+	}
+	return h.OnDisconnected(ctx, req, reason.Error)
+}
+
+// messages dispatches the invocations in the body to the handler. The results of invocations the caller awaits are
+// returned as completion messages in the protocol of the request; invocations which are not awaited fail the request
+// if their handler returns an error.
+func (uh *upstreamHandler) messages(ctx context.Context, w http.ResponseWriter, contentType string, body []byte) {
+	mediaType, err := upstreamMediaType(contentType)
+	if err != nil {
+		writeUpstreamError(w, upstreamMessageError{err: err}, http.StatusBadRequest)
+		return
+	}
+
+	msgs, err := decodeUpstreamMessages(mediaType, body)
+	if err != nil {
+		writeUpstreamError(w, upstreamMessageError{err: err}, http.StatusBadRequest)
+		return
+	}
+
+	var res bytes.Buffer
+	for _, msg := range msgs {
+		if msg.Type != invocationMessageType {
+			continue
+		}
+
+		result, hasResult, invokeErr := uh.invoker(ctx, msg)
+		if msg.InvocationID == "" {
+			if invokeErr != nil {
+				writeUpstreamError(w, invokeErr, http.StatusInternalServerError)
+				return
+			}
+			continue
+		}
+
+		record, err := encodeCompletion(mediaType, msg, result, hasResult, invokeErr)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		res.Write(record)
+	}
+
+	if res.Len() > 0 {
+		w.Header().Set("Content-Type", mediaType)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(res.Bytes())
+}
+
+// encodeCompletion encodes the completion of an invocation as a record of the protocol
+func encodeCompletion(mediaType string, msg *InvocationMessage, result interface{}, hasResult bool, invokeErr error) ([]byte, error) {
+	completion, err := newCompletionMessage(msg, result, hasResult, invokeErr)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType == msgpackContentType {
+		return completion.msgpackRecord()
+	}
+	return completion.jsonRecord()
+}
+
+func (ume upstreamMessageError) Error() string {
+	return "invalid upstream request body: " + ume.err.Error()
+}
+
+func newUpstreamRequest(r *http.Request) *UpstreamRequest {
+	query, _ := url.ParseQuery(r.Header.Get("X-ASRS-Client-Query"))
+	return &UpstreamRequest{
+		Hub:          r.Header.Get("X-ASRS-Hub"),
+		Category:     r.Header.Get("X-ASRS-Category"),
+		Event:        r.Header.Get("X-ASRS-Event"),
+		ConnectionID: r.Header.Get("X-ASRS-Connection-Id"),
+		UserID:       r.Header.Get("X-ASRS-User-Id"),
+		Query:        query,
+		Header:       r.Header,
+	}
+}
+
+// upstreamMediaType returns the hub protocol of an upstream request given its content type
+func upstreamMediaType(contentType string) (string, error) {
+	if contentType == "" {
+		return jsonContentType, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	switch mediaType {
+	case jsonContentType, msgpackContentType:
+		return mediaType, nil
+	case "text/plain":
+		return jsonContentType, nil
+	default:
+		return "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// decodeUpstreamMessages decodes the hub protocol messages in the body of an upstream request
+func decodeUpstreamMessages(mediaType string, body []byte) ([]*InvocationMessage, error) {
+	switch mediaType {
+	case jsonContentType:
+		var msgs []*InvocationMessage
+		for _, record := range splitRecords(body) {
+			var msg InvocationMessage
+			if err := json.Unmarshal(record, &msg); err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, &msg)
+		}
+		return msgs, nil
+	case msgpackContentType:
+		records, err := splitMsgpackRecords(body)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs := make([]*InvocationMessage, 0, len(records))
+		for _, record := range records {
+			msg, err := decodeMsgpackMessage(record)
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, msg)
+		}
+		return msgs, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+}
+
+// decodeMsgpackMessage decodes a MessagePack hub protocol message; only the fields of invocations are decoded
+func decodeMsgpackMessage(record []byte) (*InvocationMessage, error) {
+	value, err := decodeMsgpack(bytes.NewReader(record))
+	if err != nil {
+		return nil, err
+	}
+
+	fields, ok := value.([]interface{})
+	if !ok || len(fields) == 0 {
+		return nil, errors.New("msgpack: a message must be an array")
+	}
+
+	msgType, ok := fields[0].(int64)
+	if !ok {
+		return nil, errors.New("msgpack: the message type must be an integer")
+	}
+
+	msg := &InvocationMessage{Type: messageType(msgType)}
+	if msg.Type != invocationMessageType {
+		return msg, nil
+	}
+
+	if len(fields) < 5 {
+		return nil, errors.New("msgpack: an invocation must have at least 5 fields")
+	}
+
+	if headers, ok := fields[1].(map[string]interface{}); ok && len(headers) > 0 {
+		msg.Headers = make(map[string]string, len(headers))
+		for key, value := range headers {
+			msg.Headers[key] = fmt.Sprint(value)
+		}
+	}
+
+	if id, ok := fields[2].(string); ok {
+		msg.InvocationID = id
+	}
+
+	if msg.Target, ok = fields[3].(string); !ok {
+		return nil, errors.New("msgpack: the invocation target must be a string")
+	}
+
+	args, ok := fields[4].([]interface{})
+	if !ok {
+		return nil, errors.New("msgpack: the invocation arguments must be an array")
+	}
+
+	msg.Arguments = make([]json.RawMessage, len(args))
+	for i, arg := range args {
+		bits, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		msg.Arguments[i] = bits
+	}
+	return msg, nil
+}
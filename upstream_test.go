@@ -0,0 +1,200 @@
+package signalr_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/signalr-go"
+)
+
+type (
+	upstreamRecorder struct {
+		connected    []*signalr.UpstreamRequest
+		disconnected []string
+		echoes       []string
+		defaults     []string
+		rejectUser   string
+	}
+)
+
+func (ur *upstreamRecorder) Default(ctx context.Context, target string, args []json.RawMessage) error {
+	ur.defaults = append(ur.defaults, target)
+	return nil
+}
+
+func (ur *upstreamRecorder) Echo(ctx context.Context, message string, times int) error {
+	req, ok := signalr.UpstreamRequestFromContext(ctx)
+	if !ok {
+		return errors.New("missing upstream request")
+	}
+	ur.echoes = append(ur.echoes, req.ConnectionID+":"+strings.Repeat(message, times))
+	return nil
+}
+
+func (ur *upstreamRecorder) Fail(ctx context.Context) error {
+	return errors.New("boom")
+}
+
+func (ur *upstreamRecorder) Add(ctx context.Context, a, b int) (int, error) {
+	if a < 0 {
+		return 0, signalr.UpstreamError{Message: "a must not be negative"}
+	}
+	return a + b, nil
+}
+
+func (ur *upstreamRecorder) Greet(ctx context.Context, name string) *ComplexObject {
+	return &ComplexObject{FieldString: "hello " + name}
+}
+
+func (ur *upstreamRecorder) OnConnected(ctx context.Context, req *signalr.UpstreamRequest) (*signalr.ConnectResponse, error) {
+	switch req.UserID {
+	case ur.rejectUser:
+		return nil, signalr.UpstreamError{StatusCode: http.StatusForbidden, Message: "banned"}
+	case "anonymous":
+		return nil, errors.New("sign in required")
+	}
+
+	ur.connected = append(ur.connected, req)
+	if req.Query.Get("room") == "" {
+		return nil, nil
+	}
+	return &signalr.ConnectResponse{
+		UserID: strings.ToUpper(req.UserID),
+		Groups: []string{req.Query.Get("room")},
+		Claims: map[string]interface{}{"room": req.Query.Get("room")},
+	}, nil
+}
+
+func (ur *upstreamRecorder) OnDisconnected(ctx context.Context, req *signalr.UpstreamRequest, reason string) error {
+	ur.disconnected = append(ur.disconnected, reason)
+	return nil
+}
+
+func newUpstreamRequest(category, event, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/upstream", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-ASRS-Hub", "hub1")
+	req.Header.Set("X-ASRS-Category", category)
+	req.Header.Set("X-ASRS-Event", event)
+	req.Header.Set("X-ASRS-Connection-Id", "conn1")
+	req.Header.Set("X-ASRS-User-Id", "user1")
+	req.Header.Set("X-ASRS-Client-Query", "room=lobby")
+	return req
+}
+
+func serveUpstream(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUpstreamHandler_Events(t *testing.T) {
+	recorder := new(upstreamRecorder)
+	handler, err := signalr.NewUpstreamHandler(recorder)
+	require.NoError(t, err)
+
+	rec := serveUpstream(handler, newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, ""))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, recorder.connected, 1)
+	assert.Equal(t, "hub1", recorder.connected[0].Hub)
+	assert.Equal(t, "conn1", recorder.connected[0].ConnectionID)
+	assert.Equal(t, "user1", recorder.connected[0].UserID)
+	assert.Equal(t, "lobby", recorder.connected[0].Query.Get("room"))
+
+	body := `{"type":1,"target":"Echo","arguments":["ab",2]}` + "\x1e" + `{"type":1,"target":"unknown","arguments":[]}` + "\x1e"
+	rec = serveUpstream(handler, newUpstreamRequest(signalr.UpstreamCategoryMessages, "Echo", body))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"conn1:abab"}, recorder.echoes)
+	assert.Equal(t, []string{"unknown"}, recorder.defaults)
+
+	rec = serveUpstream(handler, newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventDisconnected, `{"error":"closed"}`))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"closed"}, recorder.disconnected)
+}
+
+func TestUpstreamHandler_Rejections(t *testing.T) {
+	recorder := new(upstreamRecorder)
+	handler, err := signalr.NewUpstreamHandler(recorder, signalr.UpstreamWithHubs("Hub1"))
+	require.NoError(t, err)
+
+	req := newUpstreamRequest(signalr.UpstreamCategoryMessages, "Echo", "")
+	req.Header.Set("X-ASRS-Hub", "other")
+	assert.Equal(t, http.StatusNotFound, serveUpstream(handler, req).Code)
+
+	req = newUpstreamRequest(signalr.UpstreamCategoryMessages, "Echo", "{")
+	assert.Equal(t, http.StatusBadRequest, serveUpstream(handler, req).Code)
+
+	req = newUpstreamRequest(signalr.UpstreamCategoryMessages, "Echo", "")
+	req.Header.Set("Content-Type", "application/xml")
+	assert.Equal(t, http.StatusBadRequest, serveUpstream(handler, req).Code)
+
+	req = newUpstreamRequest("unknown", "event", "")
+	assert.Equal(t, http.StatusBadRequest, serveUpstream(handler, req).Code)
+
+	req = newUpstreamRequest(signalr.UpstreamCategoryMessages, "Fail", `{"type":1,"target":"Fail","arguments":[]}`)
+	assert.Equal(t, http.StatusInternalServerError, serveUpstream(handler, req).Code)
+
+	req = newUpstreamRequest(signalr.UpstreamCategoryMessages, "Echo", strings.Repeat(" ", 1<<20+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serveUpstream(handler, req).Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/upstream", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, serveUpstream(handler, req).Code)
+}
+
+func TestUpstreamHandler_ConnectResponse(t *testing.T) {
+	recorder := &upstreamRecorder{rejectUser: "banned"}
+	handler, err := signalr.NewUpstreamHandler(recorder)
+	require.NoError(t, err)
+
+	rec := serveUpstream(handler, newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, ""))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"userId":"USER1","groups":["lobby"],"claims":{"room":"lobby"}}`, rec.Body.String())
+
+	req := newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, "")
+	req.Header.Del("X-ASRS-Client-Query")
+	rec = serveUpstream(handler, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	req = newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, "")
+	req.Header.Set("X-ASRS-User-Id", "banned")
+	rec = serveUpstream(handler, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "banned")
+
+	req = newUpstreamRequest(signalr.UpstreamCategoryConnections, signalr.UpstreamEventConnected, "")
+	req.Header.Set("X-ASRS-User-Id", "anonymous")
+	assert.Equal(t, http.StatusUnauthorized, serveUpstream(handler, req).Code)
+}
+
+func TestUpstreamHandler_InvocationResults(t *testing.T) {
+	handler, err := signalr.NewUpstreamHandler(new(upstreamRecorder))
+	require.NoError(t, err)
+
+	body := `{"type":1,"invocationId":"1","target":"Add","arguments":[1,2]}` + "\x1e" +
+		`{"type":1,"invocationId":"2","target":"Greet","arguments":["bob"]}` + "\x1e" +
+		`{"type":1,"invocationId":"3","target":"Echo","arguments":["a",1]}` + "\x1e" +
+		`{"type":1,"invocationId":"4","target":"Add","arguments":[-1,2]}` + "\x1e" +
+		`{"type":1,"invocationId":"5","target":"Fail","arguments":[]}` + "\x1e" +
+		`{"type":1,"target":"Add","arguments":[1,2]}` + "\x1e"
+	rec := serveUpstream(handler, newUpstreamRequest(signalr.UpstreamCategoryMessages, "Add", body))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	records := strings.Split(strings.TrimSuffix(rec.Body.String(), "\x1e"), "\x1e")
+	require.Len(t, records, 5)
+	assert.JSONEq(t, `{"type":3,"invocationId":"1","result":3}`, records[0])
+	assert.JSONEq(t, `{"type":3,"invocationId":"2","result":{"fieldString":"hello bob"}}`, records[1])
+	assert.JSONEq(t, `{"type":3,"invocationId":"3"}`, records[2])
+	assert.JSONEq(t, `{"type":3,"invocationId":"4","error":"a must not be negative"}`, records[3])
+	assert.JSONEq(t, `{"type":3,"invocationId":"5","error":"An unexpected error occurred invoking 'Fail' on the server."}`, records[4])
+}